@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
 	"srun/internal/api"
 	"srun/internal/core"
+	"srun/internal/sink"
 	"srun/internal/static"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -37,8 +43,40 @@ var (
 	dbPath             string
 	port               string
 	trustedProxiesFlag string
+	defaultTimeout     time.Duration
+	stopSignalFlag     string
+	stopGracePeriod    time.Duration
+	shutdownPolicyFlag string
+	shutdownTimeout    time.Duration
+	retentionMaxAge    time.Duration
+	retentionMaxJobs   int
+	retentionMaxLog    string
+	retentionMaxDB     string
+	retentionInterval  time.Duration
+	redactPatterns     stringList
+	redactDefaults     bool
+	sinkDir            string
+	sinkFileMaxSize    string
+	sinkFileMaxFiles   int
+	sinkSyslog         string
+	sinkHTTP           string
+	sinkHTTPHeaders    stringList
+	sinkBuffer         int
+	logBufferSize      string
 )
 
+// stringList is a flag that may be repeated, collecting its values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func ListFilesHandler(c *gin.Context) {
 	// Create a string builder to collect the file listing
 	var sb strings.Builder
@@ -90,17 +128,118 @@ func main() {
 	flag.StringVar(&port, "port", "8000", "Port to listen on")
 	flag.StringVar(&dbPath, "db", defaultDBPath(), "SQLite database path")
 	flag.StringVar(&trustedProxiesFlag, "trusted-proxies", "", "Comma-separated list of trusted proxy IPs (e.g., '127.0.0.1,192.168.1.100')")
+	flag.DurationVar(&defaultTimeout, "default-timeout", 0, "Default maximum run time for jobs without their own timeout (e.g., '30m'), 0 for no limit")
+	flag.StringVar(&stopSignalFlag, "stop-signal", "SIGTERM", "Signal sent to a job's process group when it is stopped")
+	flag.DurationVar(&stopGracePeriod, "stop-grace-period", core.DefaultStopGracePeriod, "Time to wait after the stop signal before killing a job with SIGKILL")
+	flag.StringVar(&shutdownPolicyFlag, "shutdown-policy", string(core.ShutdownStop), "What to do with running jobs on shutdown: wait, stop or detach")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for running jobs on shutdown before killing them")
+	flag.DurationVar(&retentionMaxAge, "retention-max-age", 0, "Remove finished jobs that ended longer ago (e.g., '720h'), 0 to keep them")
+	flag.IntVar(&retentionMaxJobs, "retention-max-jobs", 0, "Maximum number of finished jobs to keep, 0 for no limit")
+	flag.StringVar(&retentionMaxLog, "retention-max-job-log-size", "0", "Truncate the logs of finished jobs beyond this size, keeping head and tail (e.g., '10MB'), 0 for no limit")
+	flag.StringVar(&retentionMaxDB, "retention-max-db-size", "0", "Remove the oldest finished jobs while the database is larger (e.g., '1GB'), 0 for no limit")
+	flag.DurationVar(&retentionInterval, "retention-interval", 10*time.Minute, "How often the retention policy is applied")
+	flag.Var(&redactPatterns, "redact-pattern", "Regular expression matching secrets to redact from job output, only its first group if it has one (repeatable)")
+	flag.BoolVar(&redactDefaults, "redact-default-patterns", true, "Redact AWS keys and bearer tokens from job output")
+	flag.StringVar(&sinkDir, "sink-dir", "", "Also write the output of each job to <job ID>.log in this directory")
+	flag.StringVar(&sinkFileMaxSize, "sink-file-max-size", "100MB", "Rotate job log files in -sink-dir beyond this size, 0 for no limit")
+	flag.IntVar(&sinkFileMaxFiles, "sink-file-max-files", 5, "Number of log files kept per job in -sink-dir, including the current one")
+	flag.StringVar(&sinkSyslog, "sink-syslog", "", "Also send job output to syslog at this address (e.g., 'unix:///dev/log' or 'udp://host:514')")
+	flag.StringVar(&sinkHTTP, "sink-http", "", "Also post job output to this Loki compatible push URL (e.g., 'http://loki:3100/loki/api/v1/push')")
+	flag.Var(&sinkHTTPHeaders, "sink-http-header", "Header sent with the requests of -sink-http, as 'Name: value' (repeatable)")
+	flag.IntVar(&sinkBuffer, "sink-buffer", core.DefaultSinkBuffer, "Number of log lines buffered for each sink, beyond which lines are dropped")
+	flag.StringVar(&logBufferSize, "log-buffer-size", "1MB", "Recent output kept in memory for each running job, so clients catch up without reading the database (at most 256MB)")
 	flag.Parse()
 
+	stopSignal, err := core.ParseSignal(stopSignalFlag)
+	if err != nil {
+		log.Fatalf("Invalid -stop-signal: %v", err)
+	}
+	shutdownPolicy, err := core.ParseShutdownPolicy(shutdownPolicyFlag)
+	if err != nil {
+		log.Fatalf("Invalid -shutdown-policy: %v", err)
+	}
+
+	retention := core.RetentionPolicy{
+		MaxAge:  retentionMaxAge,
+		MaxJobs: retentionMaxJobs,
+	}
+	if retention.MaxJobLogBytes, err = core.ParseByteSize(retentionMaxLog); err != nil {
+		log.Fatalf("Invalid -retention-max-job-log-size: %v", err)
+	}
+	if retention.MaxDBBytes, err = core.ParseByteSize(retentionMaxDB); err != nil {
+		log.Fatalf("Invalid -retention-max-db-size: %v", err)
+	}
+	if retentionInterval <= 0 {
+		log.Fatalf("Invalid -retention-interval: must be positive")
+	}
+
+	logBufferBytes, err := core.ParseByteSize(logBufferSize)
+	if err != nil {
+		log.Fatalf("Invalid -log-buffer-size: %v", err)
+	}
+	if logBufferBytes <= 0 || logBufferBytes > core.MaxLogBufferSize {
+		log.Fatalf("Invalid -log-buffer-size: must be positive and at most %dMB", core.MaxLogBufferSize>>20)
+	}
+
+	var patterns []*regexp.Regexp
+	if redactDefaults {
+		patterns = append(patterns, core.DefaultRedactPatterns...)
+	}
+	for _, expr := range redactPatterns {
+		pattern, err := core.ParseRedactPattern(expr)
+		if err != nil {
+			log.Fatalf("Invalid -redact-pattern: %v", err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
 	store, err := core.NewSQLiteStorage(dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pm := &core.ProcessManager{
-		Jobs:    make(map[string]*core.Job),
-		Store:   store,
-		LogChan: make(chan core.LogMessage, 1000),
+	pm := core.NewProcessManager(store)
+	pm.DefaultTimeout = defaultTimeout
+	pm.StopSignal = stopSignal
+	pm.StopGracePeriod = stopGracePeriod
+	pm.Retention = retention
+	pm.RedactPatterns = patterns
+	pm.LogBufferSize = logBufferBytes
+
+	// Forward job output to the configured sinks
+	if sinkDir != "" {
+		maxSize, err := core.ParseByteSize(sinkFileMaxSize)
+		if err != nil {
+			log.Fatalf("Invalid -sink-file-max-size: %v", err)
+		}
+		fileSink, err := sink.NewFile(sinkDir, maxSize, sinkFileMaxFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pm.AddSink("file", fileSink, sinkBuffer)
+	}
+	if sinkSyslog != "" {
+		syslogSink, err := sink.NewSyslog(sinkSyslog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pm.AddSink("syslog", syslogSink, sinkBuffer)
+	}
+	if sinkHTTP != "" {
+		headers := http.Header{}
+		for _, header := range sinkHTTPHeaders {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				log.Fatalf("Invalid -sink-http-header: %q, expected 'Name: value'", header)
+			}
+			headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		pm.AddSink("http", sink.NewHTTP(sinkHTTP, headers), sinkBuffer)
+	}
+
+	// Resolve jobs left running by a previous server process
+	if err := pm.ReconcileJobs(); err != nil {
+		log.Printf("Warning: Couldn't reconcile running jobs: %v", err)
 	}
 
 	if envPort := os.Getenv("SRUN_PORT"); port == "" && envPort != "" {
@@ -211,7 +350,43 @@ func main() {
 	// Catch-all for SPA routes
 	r.NoRoute(serveIndexHTML)
 
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Using database at: %s", dbPath)
-	r.Run(":" + port)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Prune job history in the background
+	if retention.Enabled() {
+		go pm.RunJanitor(ctx, retentionInterval)
+	}
+
+	go func() {
+		log.Printf("Starting server on port %s", port)
+		log.Printf("Using database at: %s", dbPath)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	// A second signal terminates immediately
+	stop()
+	log.Printf("Shutting down, running jobs policy: %s", shutdownPolicy)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests first so no new jobs are started. Log
+	// streams are hijacked connections and are not waited for.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: HTTP server shutdown: %v", err)
+	}
+	if err := pm.Shutdown(shutdownCtx, shutdownPolicy); err != nil {
+		log.Printf("Warning: Job shutdown: %v", err)
+	}
+	pm.CloseSinks(shutdownCtx)
+	log.Printf("Shutdown complete")
 }
@@ -0,0 +1,134 @@
+package ansi
+
+import (
+	"html"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// linkSchemes are the URL schemes of OSC 8 hyperlinks that are rendered as
+// links. Others, like javascript:, are rendered as plain text.
+var linkSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "mailto": true}
+
+// ToHTML renders text with ANSI escape sequences as HTML, for use inside a
+// <pre> element. Colors and text attributes become styled spans and OSC 8
+// hyperlinks become links. Other escape sequences and control characters
+// are removed.
+func ToHTML(s string) string {
+	var b strings.Builder
+	w := NewHTMLWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.String()
+}
+
+// HTMLWriter renders a stream of text with ANSI escape sequences as HTML,
+// like ToHTML. The current style carries over from one write to the next,
+// and escape sequences may be split across writes.
+type HTMLWriter struct {
+	w     io.Writer
+	dec   decoder
+	style style
+	link  string
+	err   error
+
+	// Tags that are currently open
+	span     string // Style of the open span, if any
+	spanOpen bool
+	linkOpen string // Target of the open link, if any
+}
+
+// NewHTMLWriter returns an HTMLWriter writing to w.
+func NewHTMLWriter(w io.Writer) *HTMLWriter {
+	return &HTMLWriter{w: w}
+}
+
+// Write renders p as HTML.
+func (h *HTMLWriter) Write(p []byte) (int, error) {
+	h.dec.decode(p, h.text, h.apply)
+	if h.err != nil {
+		return 0, h.err
+	}
+	return len(p), nil
+}
+
+// Close closes the open tags. It doesn't close the underlying writer.
+func (h *HTMLWriter) Close() error {
+	h.closeTags(true)
+	return h.err
+}
+
+// apply updates the style or link according to an escape sequence.
+func (h *HTMLWriter) apply(seq sequence) {
+	switch {
+	case seq.kind == '[' && seq.final == 'm':
+		h.style.apply(seq.params)
+	case seq.kind == ']' && strings.HasPrefix(seq.params, "8;"):
+		h.link = linkTarget(seq.params)
+	}
+}
+
+// linkTarget returns the target of an OSC 8 hyperlink sequence, or an empty
+// string if it ends a link or the target's scheme isn't allowed.
+func linkTarget(params string) string {
+	// OSC 8 ; params ; URI, an empty URI ends the link
+	_, target, _ := strings.Cut(params[2:], ";")
+	if u, err := url.Parse(target); err == nil && linkSchemes[strings.ToLower(u.Scheme)] {
+		return target
+	}
+	return ""
+}
+
+// text writes text in the current style, without control characters.
+func (h *HTMLWriter) text(s string) {
+	s = strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\n' && r != '\t') || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	h.styled(s, h.style, h.link)
+}
+
+// styled writes text, which must not hold control characters other than
+// newlines and tabs, in a style, linking to link if it isn't empty.
+func (h *HTMLWriter) styled(s string, st style, link string) {
+	if s == "" {
+		return
+	}
+
+	css := st.css()
+	if h.linkOpen != link {
+		h.closeTags(true)
+	} else if h.spanOpen && h.span != css {
+		h.closeTags(false)
+	}
+	if link != "" && h.linkOpen == "" {
+		h.write(`<a href="` + html.EscapeString(link) + `">`)
+		h.linkOpen = link
+	}
+	if css != "" && !h.spanOpen {
+		h.write(`<span style="` + css + `">`)
+		h.span, h.spanOpen = css, true
+	}
+	h.write(html.EscapeString(s))
+}
+
+// closeTags closes the open span, and the open link if link is true.
+func (h *HTMLWriter) closeTags(link bool) {
+	if h.spanOpen {
+		h.write("</span>")
+		h.spanOpen = false
+	}
+	if link && h.linkOpen != "" {
+		h.write("</a>")
+		h.linkOpen = ""
+	}
+}
+
+func (h *HTMLWriter) write(s string) {
+	if h.err == nil {
+		_, h.err = io.WriteString(h.w, s)
+	}
+}
@@ -0,0 +1,53 @@
+package ansi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "a < b & c\n", "a &lt; b &amp; c\n"},
+		{"color", "\x1b[31mred\x1b[0m plain", `<span style="color:#cd0000">red</span> plain`},
+		{"style changes", "\x1b[1mbold\x1b[4mboth", `<span style="font-weight:bold">bold</span><span style="font-weight:bold;text-decoration:underline">both</span>`},
+		{"link", "\x1b]8;;https://example.com/?a=1&b=2\x07site\x1b]8;;\x07", `<a href="https://example.com/?a=1&amp;b=2">site</a>`},
+		{"unsafe link", "\x1b]8;;javascript:alert(1)\x07site\x1b]8;;\x07", "site"},
+		{"control characters", "a\x00b\x07c\td", "abc\td"},
+	}
+	for _, tt := range tests {
+		if got := ToHTML(tt.in); got != tt.want {
+			t.Errorf("%s: ToHTML(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLScreen(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"progress", "\r10%\r50%\r\x1b[32m100%\x1b[0m\n", `<span style="color:#00cd00">100%</span>` + "\n"},
+		{"overwritten style", "\x1b[31mfailed\x1b[0m\rok\n", `ok<span style="color:#cd0000">iled</span>` + "\n"},
+		{"style across lines", "\x1b[1mone\ntwo\x1b[0m\n", `<span style="font-weight:bold">one</span>` + "\n" + `<span style="font-weight:bold">two</span>` + "\n"},
+		{"link", "\x1b]8;;https://example.com\x07site\x1b]8;;\x07 <b>\n", `<a href="https://example.com">site</a> &lt;b&gt;` + "\n"},
+		{"unterminated line", "last", "last\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		screen := NewHTMLScreen(&b)
+		for _, part := range strings.SplitAfter(tt.in, "%") {
+			screen.Write([]byte(part))
+		}
+		if err := screen.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
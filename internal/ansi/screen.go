@@ -0,0 +1,271 @@
+package ansi
+
+import (
+	"io"
+	"strings"
+)
+
+// screenRows is how many lines the cursor can move back up to rewrite.
+// Lines further up are final.
+const screenRows = 500
+
+// screenColumns limits how far right cursor movement goes, so a sequence
+// like ESC [ 50000000 G doesn't pad a line with millions of spaces. It
+// matches the longest line the server stores.
+const screenColumns = 16 * 1024
+
+// Screen renders a stream of terminal output into the text a terminal
+// would display, writing each line once it's final. It applies carriage
+// returns, backspaces, cursor movement and erasing within the line and to
+// the lines below, so progress bars and spinners leave only their final
+// state. Other escape sequences and control characters are removed; tabs
+// are kept as they are.
+type Screen struct {
+	w        io.Writer
+	dec      decoder
+	lines    [][]cell // Lines that can still change
+	row, col int      // Cursor position within lines
+	err      error
+
+	// Rendering as HTML keeps the style of each character
+	html      *HTMLWriter
+	pen       attr            // Style and link of the text written next
+	attrs     []attr          // Styles and links of cells, indexed by cell.attr
+	attrIndex map[attr]uint16 // Positions in attrs
+}
+
+// cell is a character on the screen.
+type cell struct {
+	r    rune
+	attr uint16 // Index of its style and link in Screen.attrs
+}
+
+// attr is the style of a character and the target of the link it's in.
+type attr struct {
+	style style
+	link  string
+}
+
+// maxAttrs limits the number of distinct styles on a screen. Characters
+// written in further styles are rendered in the default style.
+const maxAttrs = 1 << 16
+
+// NewScreen returns a Screen writing the rendered lines, each terminated by
+// a newline, to w.
+func NewScreen(w io.Writer) *Screen {
+	return &Screen{w: w, lines: [][]cell{nil}}
+}
+
+// NewHTMLScreen returns a Screen writing the rendered lines to w as HTML,
+// for use inside a <pre> element. Like HTMLWriter, it keeps the colors and
+// text attributes of each character and renders OSC 8 hyperlinks as links.
+func NewHTMLScreen(w io.Writer) *Screen {
+	return &Screen{
+		w:         w,
+		lines:     [][]cell{nil},
+		html:      NewHTMLWriter(w),
+		attrs:     []attr{{}},
+		attrIndex: map[attr]uint16{{}: 0},
+	}
+}
+
+// Render returns the text that s displays on a terminal, with its lines
+// separated by newlines and none after the last line.
+func Render(s string) string {
+	plain := strings.IndexFunc(s, func(r rune) bool {
+		return (r < 0x20 && r != '\n' && r != '\t') || r == 0x7f
+	}) < 0
+	if plain {
+		return strings.TrimSuffix(s, "\n")
+	}
+
+	var b strings.Builder
+	screen := NewScreen(&b)
+	screen.Write([]byte(s))
+	screen.Close()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Write renders p.
+func (s *Screen) Write(p []byte) (int, error) {
+	s.dec.decode(p, s.text, s.apply)
+	if s.err != nil {
+		return 0, s.err
+	}
+	return len(p), nil
+}
+
+// Close writes the lines that were still on the screen, except for an
+// empty last line. It doesn't close the underlying writer.
+func (s *Screen) Close() error {
+	for i, line := range s.lines {
+		if i == len(s.lines)-1 && len(line) == 0 {
+			break
+		}
+		s.emit(line)
+	}
+	s.lines = [][]cell{nil}
+	s.row, s.col = 0, 0
+	return s.err
+}
+
+func (s *Screen) text(text string) {
+	for _, r := range text {
+		switch {
+		case r == '\n':
+			s.newline()
+		case r == '\r':
+			s.col = 0
+		case r == '\b':
+			s.col = max(s.col-1, 0)
+		case r == '\t':
+			s.put(r)
+		case r < 0x20 || r == 0x7f:
+			// Other control characters have no visible effect
+		default:
+			s.put(r)
+		}
+	}
+}
+
+// put writes a character at the cursor and advances it.
+func (s *Screen) put(r rune) {
+	line := s.lines[s.row]
+	for len(line) < s.col {
+		line = append(line, cell{r: ' '})
+	}
+	c := cell{r: r, attr: s.penAttr()}
+	if s.col < len(line) {
+		line[s.col] = c
+	} else {
+		line = append(line, c)
+	}
+	s.lines[s.row] = line
+	s.col++
+}
+
+// penAttr returns the index of the pen's style and link in s.attrs, adding
+// them if needed.
+func (s *Screen) penAttr() uint16 {
+	if s.html == nil {
+		return 0
+	}
+	i, ok := s.attrIndex[s.pen]
+	if !ok {
+		if len(s.attrs) == maxAttrs {
+			return 0
+		}
+		i = uint16(len(s.attrs))
+		s.attrs = append(s.attrs, s.pen)
+		s.attrIndex[s.pen] = i
+	}
+	return i
+}
+
+// newline moves the cursor to the start of the next line, writing the
+// lines that scroll out of reach.
+func (s *Screen) newline() {
+	s.row++
+	s.col = 0
+	if s.row < len(s.lines) {
+		return
+	}
+	s.lines = append(s.lines, nil)
+	for len(s.lines) > screenRows {
+		s.emit(s.lines[0])
+		s.lines[0] = nil
+		s.lines = s.lines[1:]
+		s.row--
+	}
+}
+
+// apply carries out the cursor movement and erasing of a CSI sequence,
+// and when rendering HTML, changes of style and links.
+func (s *Screen) apply(seq sequence) {
+	if s.html != nil {
+		switch {
+		case seq.kind == '[' && seq.final == 'm':
+			s.pen.style.apply(seq.params)
+			return
+		case seq.kind == ']' && strings.HasPrefix(seq.params, "8;"):
+			s.pen.link = linkTarget(seq.params)
+			return
+		}
+	}
+	if seq.kind != '[' || strings.ContainsAny(seq.params, "?<=>") {
+		return
+	}
+	n := max(atoi(seq.params), 1)
+	last := len(s.lines) - 1
+
+	switch seq.final {
+	case 'A': // Cursor up
+		s.row = max(s.row-n, 0)
+	case 'B': // Cursor down
+		s.row = min(s.row+n, last)
+	case 'C': // Cursor forward
+		if s.col < screenColumns {
+			s.col = min(s.col+n, screenColumns)
+		}
+	case 'D': // Cursor back
+		s.col = max(s.col-n, 0)
+	case 'E': // Start of a following line
+		s.row, s.col = min(s.row+n, last), 0
+	case 'F': // Start of a preceding line
+		s.row, s.col = max(s.row-n, 0), 0
+	case 'G': // Column
+		s.col = min(n, screenColumns) - 1
+	case 'K': // Erase in line
+		line := s.lines[s.row]
+		switch atoi(seq.params) {
+		case 0:
+			s.lines[s.row] = line[:min(s.col, len(line))]
+		case 1:
+			for i := 0; i <= s.col && i < len(line); i++ {
+				line[i] = cell{r: ' '}
+			}
+		case 2:
+			s.lines[s.row] = nil
+		}
+	case 'J': // Erase below
+		if atoi(seq.params) == 0 {
+			line := s.lines[s.row]
+			s.lines[s.row] = line[:min(s.col, len(line))]
+			clear(s.lines[s.row+1:])
+			s.lines = s.lines[:s.row+1]
+		}
+	}
+}
+
+func (s *Screen) emit(line []cell) {
+	if s.html != nil {
+		s.emitHTML(line)
+		return
+	}
+	if s.err == nil {
+		text := make([]rune, len(line)+1)
+		for i, c := range line {
+			text[i] = c.r
+		}
+		text[len(line)] = '\n'
+		_, s.err = io.WriteString(s.w, string(text))
+	}
+}
+
+// emitHTML writes a line as HTML, a run of characters of the same style at
+// a time.
+func (s *Screen) emitHTML(line []cell) {
+	var run []rune
+	for i, c := range line {
+		run = append(run, c.r)
+		if i == len(line)-1 || line[i+1].attr != c.attr {
+			a := s.attrs[c.attr]
+			s.html.styled(string(run), a.style, a.link)
+			run = run[:0]
+		}
+	}
+	s.html.styled("\n", style{}, "")
+	if s.err == nil {
+		s.err = s.html.err
+	}
+}
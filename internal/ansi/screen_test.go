@@ -0,0 +1,62 @@
+package ansi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello\nworld\n", "hello\nworld"},
+		{"tab kept", "a\tb", "a\tb"},
+		{"colors removed", "\x1b[31mred\x1b[0m", "red"},
+		{"carriage return", "50%\r100%", "100%"},
+		{"carriage return shorter", "loading...\rdone", "doneing..."},
+		{"erase line", "loading...\r\x1b[Kdone", "done"},
+		{"backspace", "abc\bd", "abd"},
+		{"cursor up", "one\ntwo\n\x1b[2Aure\n", "ure\ntwo"},
+		{"cursor forward", "a\x1b[3Cb", "a   b"},
+		{"column", "abcdef\x1b[3GX", "abXdef"},
+		{"erase below", "one\ntwo\nthree\x1b[2A\r\x1b[J", ""},
+		{"control characters removed", "a\x00b\x07c", "abc"},
+		{"private sequences ignored", "\x1b[?25lhidden\x1b[?25h", "hidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderLimitsCursorMovement(t *testing.T) {
+	tests := []string{
+		"\x1b[50000000Gx",
+		"\x1b[50000000Cx",
+		"\x1b[9999999999999999999999Gx",
+		strings.Repeat("\x1b[10000C", 1000) + "x",
+	}
+	for _, in := range tests {
+		got := Render(in)
+		if len(got) > screenColumns+1 || !strings.HasSuffix(got, "x") {
+			t.Errorf("Render(%.20q...) has length %d, want at most %d ending in x", in, len(got), screenColumns+1)
+		}
+	}
+}
+
+func TestScreenSplitWrites(t *testing.T) {
+	var b strings.Builder
+	screen := NewScreen(&b)
+	for _, part := range []string{"pro", "gress \x1b", "[3", "1m50%\r", "done\xe2\x9c", "\x93\n"} {
+		screen.Write([]byte(part))
+	}
+	screen.Close()
+	if want := "done✓ess 50%\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,134 @@
+package ansi
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSequenceLength is the longest escape sequence that is buffered while
+// waiting for its end. An escape that goes on longer is treated as text.
+const maxSequenceLength = 4096
+
+// sequence is a terminal escape sequence.
+type sequence struct {
+	kind   byte   // '[' for CSI, ']' for OSC, or the final byte of other escapes, 0 if malformed
+	params string // Parameters of a CSI sequence or payload of an OSC sequence
+	final  byte   // Final byte of a CSI sequence
+}
+
+// parseSequence parses the escape sequence at the start of s, which must
+// start with ESC, and returns it with its length. The length is -1 if s ends
+// before the sequence does.
+func parseSequence(s string) (sequence, int) {
+	if len(s) < 2 {
+		return sequence{}, -1
+	}
+
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; {
+			case c >= 0x40 && c <= 0x7e:
+				return sequence{kind: '[', params: s[2:i], final: c}, i + 1
+			case c < 0x20 || c > 0x7e:
+				// Malformed, the sequence ends before the unexpected byte
+				return sequence{}, i
+			}
+		}
+	case ']':
+		// Terminated by BEL or ST (ESC \)
+		for i := 2; i < len(s); i++ {
+			switch s[i] {
+			case '\a':
+				return sequence{kind: ']', params: s[2:i]}, i + 1
+			case '\x1b':
+				if i+1 == len(s) {
+					return sequence{}, -1
+				}
+				if s[i+1] == '\\' {
+					return sequence{kind: ']', params: s[2:i]}, i + 2
+				}
+				return sequence{}, i
+			}
+		}
+	default:
+		// Intermediate bytes followed by a final byte, like ESC ( B
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			if c < 0x20 || c > 0x7e {
+				return sequence{}, i
+			}
+			if c > 0x2f {
+				return sequence{kind: c}, i + 1
+			}
+		}
+	}
+	return sequence{}, -1
+}
+
+// decoder splits a stream of terminal output into text and escape
+// sequences. Sequences and UTF-8 encoded characters split across writes
+// are held back until they are complete.
+type decoder struct {
+	pending string
+}
+
+// decode calls text for the runs of text in p, and seq for the escape
+// sequences between them.
+func (d *decoder) decode(p []byte, text func(string), seq func(sequence)) {
+	s := d.pending + string(p)
+	d.pending = ""
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\x1b')
+		if i < 0 {
+			n := len(s) - incompleteRune(s)
+			text(s[:n])
+			d.pending = s[n:]
+			return
+		}
+		if i > 0 {
+			text(s[:i])
+		}
+		s = s[i:]
+
+		parsed, n := parseSequence(s)
+		if n < 0 {
+			if len(s) < maxSequenceLength {
+				d.pending = s
+				return
+			}
+			// Not a sequence after all, drop the escape
+			n = 1
+		}
+		seq(parsed)
+		s = s[n:]
+	}
+}
+
+// incompleteRune returns the length of the incomplete UTF-8 encoded
+// character at the end of s, if any.
+func incompleteRune(s string) int {
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if utf8.FullRuneInString(s[i:]) {
+				return 0
+			}
+			return len(s) - i
+		}
+	}
+	return 0
+}
+
+// Strip removes the escape sequences from s and leaves everything else,
+// control characters included, as it is. A sequence cut off at the end of
+// s is removed too.
+func Strip(s string) string {
+	var b strings.Builder
+	var dec decoder
+	dec.decode([]byte(s), func(text string) { b.WriteString(text) }, func(sequence) {})
+	if !strings.HasPrefix(dec.pending, "\x1b") {
+		b.WriteString(dec.pending)
+	}
+	return b.String()
+}
@@ -0,0 +1,18 @@
+package ansi
+
+import "testing"
+
+func TestStrip(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{"\x1b[1;31mred\x1b[0m", "red"},
+		{"keeps\x02controls\x03\r", "keeps\x02controls\x03\r"},
+		{"\x1b]8;;https://example.com\x07link\x1b]8;;\x07", "link"},
+		{"cut off \x1b[3", "cut off "},
+	}
+	for _, tt := range tests {
+		if got := Strip(tt.in); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package ansi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Colors of text without an explicit color, as in xterm's default theme.
+const (
+	DefaultForeground = "#e5e5e5"
+	DefaultBackground = "#000000"
+)
+
+// palette holds the 16 basic colors of xterm's default theme.
+var palette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// style is the graphic rendition of text, as set by SGR sequences. Colors
+// are CSS colors, empty for the default.
+type style struct {
+	fg, bg    string
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+	inverse   bool
+	strike    bool
+}
+
+// apply updates the style with the parameters of an SGR sequence.
+func (s *style) apply(params string) {
+	parts := strings.Split(params, ";")
+	for i := 0; i < len(parts); i++ {
+		// Colors may use colon separated sub-parameters, as in 38:2::255:0:0
+		if sub := strings.Split(parts[i], ":"); len(sub) > 1 {
+			switch atoi(sub[0]) {
+			case 4:
+				s.underline = atoi(sub[1]) != 0
+			case 38:
+				s.fg, _ = extendedColor(sub[1:], true)
+			case 48:
+				s.bg, _ = extendedColor(sub[1:], true)
+			}
+			continue
+		}
+
+		switch n := atoi(parts[i]); {
+		case n == 0:
+			*s = style{}
+		case n == 1:
+			s.bold = true
+		case n == 2:
+			s.dim = true
+		case n == 3:
+			s.italic = true
+		case n == 4 || n == 21:
+			s.underline = true
+		case n == 7:
+			s.inverse = true
+		case n == 9:
+			s.strike = true
+		case n == 22:
+			s.bold, s.dim = false, false
+		case n == 23:
+			s.italic = false
+		case n == 24:
+			s.underline = false
+		case n == 27:
+			s.inverse = false
+		case n == 29:
+			s.strike = false
+		case n >= 30 && n <= 37:
+			s.fg = palette[n-30]
+		case n == 38:
+			color, used := extendedColor(parts[i+1:], false)
+			s.fg = color
+			i += used
+		case n == 39:
+			s.fg = ""
+		case n >= 40 && n <= 47:
+			s.bg = palette[n-40]
+		case n == 48:
+			color, used := extendedColor(parts[i+1:], false)
+			s.bg = color
+			i += used
+		case n == 49:
+			s.bg = ""
+		case n >= 90 && n <= 97:
+			s.fg = palette[n-90+8]
+		case n >= 100 && n <= 107:
+			s.bg = palette[n-100+8]
+		}
+	}
+}
+
+// extendedColor parses the arguments of a 256 color (5;n) or truecolor
+// (2;r;g;b) SGR parameter. It returns the color and how many arguments it
+// used. In the colon separated form, truecolor may include an empty color
+// space argument before the components.
+func extendedColor(args []string, colons bool) (string, int) {
+	if len(args) == 0 {
+		return "", 0
+	}
+	switch atoi(args[0]) {
+	case 5:
+		if len(args) < 2 {
+			return "", len(args)
+		}
+		return color256(atoi(args[1])), 2
+	case 2:
+		rgb := args[1:]
+		if colons && len(rgb) > 3 {
+			rgb = rgb[1:]
+		}
+		if len(rgb) < 3 {
+			return "", len(args)
+		}
+		return fmt.Sprintf("#%02x%02x%02x", clamp(atoi(rgb[0])), clamp(atoi(rgb[1])), clamp(atoi(rgb[2]))), 4
+	}
+	return "", 1
+}
+
+// color256 returns a color of the xterm 256 color palette.
+func color256(n int) string {
+	switch {
+	case n < 0 || n > 255:
+		return ""
+	case n < 16:
+		return palette[n]
+	case n < 232:
+		// 6x6x6 color cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		// Grayscale ramp
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+// css returns the style as the value of an HTML style attribute.
+func (s style) css() string {
+	fg, bg := s.fg, s.bg
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = DefaultBackground
+		}
+		if bg == "" {
+			bg = DefaultForeground
+		}
+	}
+
+	var props []string
+	if fg != "" {
+		props = append(props, "color:"+fg)
+	}
+	if bg != "" {
+		props = append(props, "background-color:"+bg)
+	}
+	if s.bold {
+		props = append(props, "font-weight:bold")
+	}
+	if s.dim {
+		props = append(props, "opacity:0.7")
+	}
+	if s.italic {
+		props = append(props, "font-style:italic")
+	}
+	switch {
+	case s.underline && s.strike:
+		props = append(props, "text-decoration:underline line-through")
+	case s.underline:
+		props = append(props, "text-decoration:underline")
+	case s.strike:
+		props = append(props, "text-decoration:line-through")
+	}
+	return strings.Join(props, ";")
+}
+
+// atoi parses a numeric parameter, treating missing and invalid ones as 0.
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func clamp(n int) int {
+	return max(0, min(n, 255))
+}
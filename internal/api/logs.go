@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"srun/internal/ansi"
+	"srun/internal/core"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits for the number of lines returned by the log lines endpoint.
+const (
+	defaultLogLinesLimit = 1000
+	maxLogLinesLimit     = 10000
+)
+
+// logLine is the JSON representation of a log line returned by the log
+// lines endpoint.
+type logLine struct {
+	Seq     int64     `json:"seq"`
+	Stream  string    `json:"stream"`
+	Text    string    `json:"text"` // Plain text
+	Raw     string    `json:"raw"`  // Text with ANSI codes and line terminator
+	Partial bool      `json:"partial,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// Fields of JSON log lines
+	Level    string     `json:"level,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	LoggedAt *time.Time `json:"loggedAt,omitempty"`
+}
+
+func newLogLine(log core.LogMessage) logLine {
+	line := logLine{
+		Seq:     log.Seq,
+		Stream:  log.Stream,
+		Text:    log.Text,
+		Raw:     log.RawText,
+		Partial: log.Partial,
+		Time:    log.Time,
+		Level:   log.Level,
+		Message: log.Message,
+	}
+	if !log.LoggedAt.IsZero() {
+		line.LoggedAt = &log.LoggedAt
+	}
+	return line
+}
+
+// parseLevel reads the level query parameter, the minimum level of JSON log
+// lines to return.
+func parseLevel(c *gin.Context) (string, error) {
+	if c.Query("level") == "" {
+		return "", nil
+	}
+	return core.ParseLogLevel(c.Query("level"))
+}
+
+// parseLogQuery builds a log query from the request's query parameters.
+func parseLogQuery(c *gin.Context) (core.LogQuery, error) {
+	q := core.LogQuery{
+		JobID:  c.Param("id"),
+		Stream: c.Query("stream"),
+		Limit:  defaultLogLinesLimit,
+	}
+	switch q.Stream {
+	case "", core.StreamStdout, core.StreamStderr:
+	default:
+		return q, fmt.Errorf("invalid stream %q, expected stdout or stderr", q.Stream)
+	}
+	level, err := parseLevel(c)
+	if err != nil {
+		return q, err
+	}
+	q.Level = level
+
+	intParam := func(name string, min, max int64) (int64, error) {
+		value, err := strconv.ParseInt(c.Query(name), 10, 64)
+		if err != nil || value < min || value > max {
+			return 0, fmt.Errorf("invalid %s %q, expected a number from %d to %d", name, c.Query(name), min, max)
+		}
+		return value, nil
+	}
+	timeParam := func(name string) (time.Time, error) {
+		value, err := time.Parse(time.RFC3339Nano, c.Query(name))
+		if err != nil {
+			return value, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", name, c.Query(name))
+		}
+		return value, nil
+	}
+
+	if c.Query("tail") != "" {
+		if c.Query("from") != "" {
+			return q, fmt.Errorf("tail can't be combined with from")
+		}
+		tail, err := intParam("tail", 1, maxLogLinesLimit)
+		if err != nil {
+			return q, err
+		}
+		q.Tail = int(tail)
+	}
+	if c.Query("from") != "" {
+		from, err := intParam("from", 1, 1<<62)
+		if err != nil {
+			return q, err
+		}
+		q.From = from
+	}
+	if c.Query("limit") != "" {
+		limit, err := intParam("limit", 1, maxLogLinesLimit)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = int(limit)
+	}
+	if c.Query("start") != "" {
+		start, err := timeParam("start")
+		if err != nil {
+			return q, err
+		}
+		q.Start = start
+	}
+	if c.Query("end") != "" {
+		end, err := timeParam("end")
+		if err != nil {
+			return q, err
+		}
+		q.End = end
+	}
+	return q, nil
+}
+
+// logLinesHandler returns a page of a job's log lines, either from a
+// sequence number onwards or the last lines of the log.
+func logLinesHandler(pm *core.ProcessManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		job, err := pm.GetJob(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+			return
+		}
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+
+		q, err := parseLogQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Ask for one more line than requested to tell if there are more
+		limit := q.Limit
+		if q.Tail == 0 {
+			q.Limit++
+		}
+		logs, err := pm.QueryLogs(q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get logs: " + err.Error()})
+			return
+		}
+		more := q.Tail == 0 && len(logs) > limit
+		if more {
+			logs = logs[:limit]
+		}
+
+		// Clients continue from next, to read the following page or to
+		// poll a running job for new output
+		next := q.From
+		if next == 0 {
+			next = 1
+		}
+		lines := make([]logLine, 0, len(logs))
+		for _, log := range logs {
+			lines = append(lines, newLogLine(log))
+			next = log.Seq + 1
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"lines": lines,
+			"next":  next,
+			"more":  more,
+		})
+	}
+}
+
+// logDownloadFormats maps the formats of the log download endpoint to their
+// content type and file extension.
+var logDownloadFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"text":   {"text/plain; charset=utf-8", ".log"},
+	"raw":    {"text/plain; charset=utf-8", ".ansi.log"},
+	"ndjson": {"application/x-ndjson", ".ndjson"},
+	"html":   {"text/html; charset=utf-8", ".html"},
+}
+
+// htmlLogHeader starts the standalone page of a log downloaded as HTML.
+func htmlLogHeader(job *core.Job) string {
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>%s</title></head>
+<body style="margin:0;background-color:%s">
+<pre style="margin:0;padding:1em;color:%s;background-color:%s;white-space:pre-wrap">`,
+		html.EscapeString(job.Command), ansi.DefaultBackground, ansi.DefaultForeground, ansi.DefaultBackground)
+}
+
+const htmlLogFooter = "</pre>\n</body>\n</html>\n"
+
+// downloadLogsHandler streams a job's complete log as a file attachment,
+// either as plain text, with ANSI codes, as one JSON object per line, or
+// rendered as an HTML page.
+func downloadLogsHandler(pm *core.ProcessManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		job, err := pm.GetJob(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+			return
+		}
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+
+		name := c.DefaultQuery("format", "text")
+		format, ok := logDownloadFormats[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid format %q, expected text, raw, ndjson or html", name)})
+			return
+		}
+		q := core.LogQuery{JobID: id, Stream: c.Query("stream")}
+		switch q.Stream {
+		case "", core.StreamStdout, core.StreamStderr:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid stream %q, expected stdout or stderr", q.Stream)})
+			return
+		}
+		if q.Level, err = parseLevel(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", format.contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="job-%s%s"`, id, format.extension))
+		c.Status(http.StatusOK)
+
+		w := bufio.NewWriter(c.Writer)
+		enc := json.NewEncoder(w)
+		screen := ansi.NewScreen(w)
+		if name == "html" {
+			w.WriteString(htmlLogHeader(job))
+			screen = ansi.NewHTMLScreen(w)
+		}
+		open := false // Whether the last line lacks its newline
+		partial := "" // Stream of the last line, if it continues in the next message
+		err = pm.StreamLogs(q, func(log core.LogMessage) error {
+			switch name {
+			case "raw":
+				_, err := w.WriteString(log.RawText)
+				return err
+			case "ndjson":
+				return enc.Encode(newLogLine(log))
+			default:
+				// Text and HTML are rendered as a terminal displays the
+				// output, so progress bars rewriting their line over
+				// several messages end up as one line. A partial line continues in the next
+				// message of its stream, unless another stream interrupts
+				// it, other lines without a newline end with their stream.
+				if open && partial != log.Stream {
+					if _, err := screen.Write([]byte("\n")); err != nil {
+						return err
+					}
+				}
+				open = !strings.HasSuffix(log.RawText, "\n")
+				partial = ""
+				if log.Partial {
+					partial = log.Stream
+				}
+				_, err := screen.Write([]byte(log.RawText))
+				return err
+			}
+		})
+		if err == nil && (name == "text" || name == "html") {
+			err = screen.Close()
+		}
+		if err == nil && name == "html" {
+			_, err = w.WriteString(htmlLogFooter)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			// The response has already started, so the error can't be
+			// reported to the client
+			fmt.Fprintf(gin.DefaultErrorWriter, "Failed to download logs of job %s: %v\n", id, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"srun/internal/core"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logLinesPage is the response of the log lines endpoint.
+type logLinesPage struct {
+	Lines []logLine `json:"lines"`
+	Next  int64     `json:"next"`
+	More  bool      `json:"more"`
+}
+
+// getLogLines requests the log lines of a job and returns the status code
+// and, for successful requests, the page.
+func getLogLines(t *testing.T, srv *httptest.Server, id, query string) (int, logLinesPage) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/api/jobs/" + id + "/logs/lines?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var page logLinesPage
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, page
+}
+
+func TestLogLinesPages(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: "seq 1 5; sleep 0.1; echo six >&2; sleep 0.1; seq 7 10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, pm, job.ID)
+
+	tests := []struct {
+		query string
+		text  []string
+		next  int64
+		more  bool
+	}{
+		{"", []string{"1", "2", "3", "4", "5", "six", "7", "8", "9", "10"}, 11, false},
+		{"limit=4", []string{"1", "2", "3", "4"}, 5, true},
+		{"from=5&limit=4", []string{"5", "six", "7", "8"}, 9, true},
+		{"from=9&limit=2", []string{"9", "10"}, 11, false},
+		{"from=11", nil, 11, false},
+		{"tail=3", []string{"8", "9", "10"}, 11, false},
+		{"tail=3&stream=stderr", []string{"six"}, 7, false},
+		{"stream=stdout&limit=5&from=4", []string{"4", "5", "7", "8", "9"}, 10, true},
+	}
+	for _, tt := range tests {
+		status, page := getLogLines(t, srv, job.ID, tt.query)
+		if status != http.StatusOK {
+			t.Errorf("%q: got status %d", tt.query, status)
+			continue
+		}
+		var text []string
+		for _, line := range page.Lines {
+			text = append(text, line.Text)
+		}
+		if !slices.Equal(text, tt.text) || page.Next != tt.next || page.More != tt.more {
+			t.Errorf("%q: got %q, next %d, more %v, want %q, next %d, more %v",
+				tt.query, text, page.Next, page.More, tt.text, tt.next, tt.more)
+		}
+	}
+
+	// Following the cursor reads every line once
+	var seqs []int64
+	for next, more := int64(1), true; more; {
+		_, page := getLogLines(t, srv, job.ID, "limit=3&from="+strconv.FormatInt(next, 10))
+		for _, line := range page.Lines {
+			seqs = append(seqs, line.Seq)
+		}
+		next, more = page.Next, page.More
+	}
+	if want := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}; !slices.Equal(seqs, want) {
+		t.Errorf("following next got %v, want %v", seqs, want)
+	}
+}
+
+func TestLogLinesInvalidQuery(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, pm, job.ID)
+
+	for _, query := range []string{
+		"tail=0",
+		"tail=10001",
+		"tail=5&from=1",
+		"from=0",
+		"from=x",
+		"limit=0",
+		"limit=-1",
+		"stream=stdin",
+		"start=yesterday",
+	} {
+		if status, _ := getLogLines(t, srv, job.ID, query); status != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+	if status, _ := getLogLines(t, srv, "unknown", ""); status != http.StatusNotFound {
+		t.Errorf("unknown job: got status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestDownloadLogs(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: `printf '\033[31mred\033[0m\n'; sleep 0.1; echo err >&2; sleep 0.1; echo plain`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, pm, job.ID)
+
+	download := func(query string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/jobs/" + job.ID + "/logs/download?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	tests := []struct {
+		query       string
+		contentType string
+		filename    string
+		body        string
+	}{
+		{"", "text/plain; charset=utf-8", "job-" + job.ID + ".log", "red\nerr\nplain\n"},
+		{"format=raw", "text/plain; charset=utf-8", "job-" + job.ID + ".ansi.log", "\x1b[31mred\x1b[0m\nerr\nplain\n"},
+		{"format=text&stream=stderr", "text/plain; charset=utf-8", "job-" + job.ID + ".log", "err\n"},
+	}
+	for _, tt := range tests {
+		resp, body := download(tt.query)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%q: got status %d", tt.query, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%q: got content type %q, want %q", tt.query, got, tt.contentType)
+		}
+		if got, want := resp.Header.Get("Content-Disposition"), `attachment; filename="`+tt.filename+`"`; got != want {
+			t.Errorf("%q: got content disposition %q, want %q", tt.query, got, want)
+		}
+		if body != tt.body {
+			t.Errorf("%q: got %q, want %q", tt.query, body, tt.body)
+		}
+	}
+
+	resp, body := download("format=ndjson")
+	if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("ndjson: got content type %q", got)
+	}
+	var lines []logLine
+	dec := json.NewDecoder(strings.NewReader(body))
+	for dec.More() {
+		var line logLine
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	want := []logLine{
+		{Seq: 1, Stream: core.StreamStdout, Text: "red", Raw: "\x1b[31mred\x1b[0m\n"},
+		{Seq: 2, Stream: core.StreamStderr, Text: "err", Raw: "err\n"},
+		{Seq: 3, Stream: core.StreamStdout, Text: "plain", Raw: "plain\n"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("ndjson: got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if line.Time.IsZero() {
+			t.Errorf("ndjson line %d has no time", i)
+		}
+		line.Time = time.Time{}
+		if line != want[i] {
+			t.Errorf("ndjson line %d: got %+v, want %+v", i, line, want[i])
+		}
+	}
+
+	resp, body = download("format=html")
+	if got := resp.Header.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("html: got content type %q", got)
+	}
+	if want := `<span style="color:#cd0000">red</span>` + "\nerr\nplain\n"; !strings.Contains(body, want) || !strings.HasSuffix(body, "</html>\n") {
+		t.Errorf("html: got %q, want a page holding %q", body, want)
+	}
+
+	for _, query := range []string{"format=html5", "stream=stdin"} {
+		if resp, _ := download(query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"srun/internal/core"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pinJobHandler pins or unpins a job, which exempts it from the retention
+// policy.
+func pinJobHandler(pm *core.ProcessManager, pinned bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := pm.PinJob(id, pinned); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update job: " + err.Error(),
+			})
+			return
+		}
+
+		job, err := pm.GetJob(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, jobResponse(job))
+	}
+}
+
+// retentionHandler returns the retention policy and what it removed the
+// last time it was applied.
+func retentionHandler(pm *core.ProcessManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := pm.Retention
+		resp := gin.H{
+			"policy": gin.H{
+				"maxAgeSeconds":  int64(policy.MaxAge / time.Second),
+				"maxJobs":        policy.MaxJobs,
+				"maxJobLogBytes": policy.MaxJobLogBytes,
+				"maxDbBytes":     policy.MaxDBBytes,
+			},
+		}
+
+		if report := pm.LastRetentionReport(); report != nil {
+			last := gin.H{
+				"time":          report.Time.Format(time.RFC3339),
+				"removedJobs":   emptyIfNil(report.RemovedJobs),
+				"truncatedJobs": emptyIfNil(report.TruncatedJobs),
+				"removedLines":  report.RemovedLines,
+				"removedBytes":  report.RemovedBytes,
+				"dbBytes":       report.DBBytes,
+			}
+			if report.Error != "" {
+				last["error"] = report.Error
+			}
+			resp["lastRun"] = last
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// emptyIfNil makes nil slices encode as empty JSON arrays.
+func emptyIfNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"srun/internal/core"
 	"srun/internal/version"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +17,36 @@ import (
 )
 
 type CreateJobRequest struct {
-	Command string `json:"command" binding:"required"`
+	Command  string            `json:"command" binding:"required"`
+	Env      map[string]string `json:"env"`
+	CleanEnv bool              `json:"cleanEnv"`
+	Cwd      string            `json:"cwd"`
+	// TimeoutSeconds limits how long the job may run, 0 uses the server default
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// TTY runs the job attached to a pseudo-terminal
+	TTY bool `json:"tty"`
+	// Secrets names variables in Env whose values are redacted from the
+	// job's output and from API responses
+	Secrets []string `json:"secrets"`
+	// JSONLogs parses output lines holding JSON objects as structured logs
+	JSONLogs bool `json:"jsonLogs"`
+	// LogBufferBytes is how much recent output is kept in memory for
+	// clients catching up with the job, 0 uses the server default
+	LogBufferBytes int64 `json:"logBufferBytes"`
+}
+
+func (r CreateJobRequest) spec() core.JobSpec {
+	return core.JobSpec{
+		Command:       r.Command,
+		Env:           r.Env,
+		CleanEnv:      r.CleanEnv,
+		Cwd:           r.Cwd,
+		Timeout:       time.Duration(r.TimeoutSeconds) * time.Second,
+		TTY:           r.TTY,
+		Secrets:       r.Secrets,
+		JSONLogs:      r.JSONLogs,
+		LogBufferSize: r.LogBufferBytes,
+	}
 }
 
 func SetupRoutes(r gin.IRoutes, pm *core.ProcessManager) {
@@ -28,9 +62,81 @@ func SetupRoutes(r gin.IRoutes, pm *core.ProcessManager) {
 	r.DELETE("/api/jobs/:id", removeJobHandler(pm))
 	r.POST("/api/jobs/:id/stop", stopJobHandler(pm))
 	r.POST("/api/jobs/:id/restart", restartJobHandler(pm))
+	r.POST("/api/jobs/:id/signal", signalJobHandler(pm))
+	r.POST("/api/jobs/:id/pin", pinJobHandler(pm, true))
+	r.POST("/api/jobs/:id/unpin", pinJobHandler(pm, false))
 
-	// Log streaming endpoint
+	// Log endpoints
 	r.GET("/api/jobs/:id/logs", streamLogsHandler(pm))
+	r.GET("/api/jobs/:id/logs/lines", logLinesHandler(pm))
+	r.GET("/api/jobs/:id/logs/download", downloadLogsHandler(pm))
+
+	// Search endpoint
+	r.GET("/api/search", searchHandler(pm))
+
+	// Retention endpoint
+	r.GET("/api/retention", retentionHandler(pm))
+}
+
+// jobResponse converts a job into its JSON representation.
+func jobResponse(job *core.Job) gin.H {
+	resp := gin.H{
+		"id":        job.ID,
+		"command":   job.Command,
+		"status":    job.Status,
+		"pid":       job.PID,
+		"startedAt": job.StartedAt.Format(time.RFC3339),
+		"pinned":    job.Pinned,
+	}
+	// Only include completedAt if it's not zero time
+	if !job.CompletedAt.IsZero() {
+		resp["completedAt"] = job.CompletedAt.Format(time.RFC3339)
+	}
+	if len(job.Env) > 0 || len(job.Secrets) > 0 {
+		env := make(map[string]string, len(job.Env))
+		for key, value := range job.Env {
+			env[key] = value
+		}
+		for _, name := range job.Secrets {
+			env[name] = core.RedactionMask
+		}
+		resp["env"] = env
+	}
+	if len(job.Secrets) > 0 {
+		resp["secrets"] = job.Secrets
+	}
+	if job.CleanEnv {
+		resp["cleanEnv"] = true
+	}
+	if job.Cwd != "" {
+		resp["cwd"] = job.Cwd
+	}
+	if job.Timeout > 0 {
+		resp["timeoutSeconds"] = int(job.Timeout / time.Second)
+	}
+	if job.TTY {
+		resp["tty"] = true
+	}
+	if job.JSONLogs {
+		resp["jsonLogs"] = true
+	}
+	if job.LogBufferSize > 0 {
+		resp["logBufferBytes"] = job.LogBufferSize
+	}
+	// Exit information is only known once the process has finished
+	if job.ExitCode != nil {
+		resp["exitCode"] = *job.ExitCode
+	}
+	if job.Signal != "" {
+		resp["signal"] = job.Signal
+	}
+	if job.Reason != "" {
+		resp["reason"] = job.Reason
+	}
+	if metadata := job.Metadata(); metadata != nil {
+		resp["metadata"] = metadata
+	}
+	return resp
 }
 
 func removeJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
@@ -49,12 +155,136 @@ func removeJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 	}
 }
 
-func sendBatch(ws *websocket.Conn, batch []core.LogMessage) {
+// logFrame is the JSON representation of a log message sent to WebSocket
+// clients that connect with ?format=json.
+type logFrame struct {
+	Type    string    `json:"type"`
+	Seq     int64     `json:"seq,omitempty"`
+	Stream  string    `json:"stream"`
+	Text    string    `json:"text"`
+	Partial bool      `json:"partial,omitempty"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level,omitempty"`
+	// Display is a readable rendering of a JSON log line for terminals
+	Display string `json:"display,omitempty"`
+}
+
+// eventFrame is sent to WebSocket clients that connect with ?format=json
+// after a log message holding a workflow command.
+type eventFrame struct {
+	Type string `json:"type"`
+	core.JobEvent
+}
+
+// logStreamOptions holds the query parameters accepted by the log
+// streaming endpoint.
+type logStreamOptions struct {
+	Stream string // only send messages from this stream, empty for all
+	Format string // "text" for raw terminal output, "json" for logFrame objects
+	Since  int64  // only send messages with a greater sequence number
+	Level  string // only send JSON log lines of at least this level
+}
+
+func parseLogStreamOptions(c *gin.Context) (logStreamOptions, error) {
+	opts := logStreamOptions{
+		Stream: c.Query("stream"),
+		Format: c.DefaultQuery("format", "text"),
+	}
+	switch opts.Stream {
+	case "", core.StreamStdout, core.StreamStderr:
+	default:
+		return opts, fmt.Errorf("invalid stream %q, expected stdout or stderr", opts.Stream)
+	}
+	switch opts.Format {
+	case "text", "json":
+	default:
+		return opts, fmt.Errorf("invalid format %q, expected text or json", opts.Format)
+	}
+	if since := c.Query("since"); since != "" {
+		seq, err := strconv.ParseInt(since, 10, 64)
+		if err != nil || seq < 0 {
+			return opts, fmt.Errorf("invalid since %q, expected a sequence number", since)
+		}
+		opts.Since = seq
+	}
+	if level := c.Query("level"); level != "" {
+		parsed, err := core.ParseLogLevel(level)
+		if err != nil {
+			return opts, err
+		}
+		opts.Level = parsed
+	}
+	return opts, nil
+}
+
+func (o logStreamOptions) matches(msg core.LogMessage) bool {
+	return (o.Stream == "" || o.Stream == msg.Stream) &&
+		(o.Level == "" || core.LevelAtLeast(msg.Level, o.Level))
+}
+
+// sentLines tracks the text of unfinished lines sent to a client. Previews
+// hold all of a line printed so far, and the numbered message all of the
+// line, but a client is only sent the text it hasn't received yet, so the
+// text of all messages adds up to the job's output.
+type sentLines map[string]string // Text of the unfinished line sent so far, by stream
+
+// unsent returns the text of msg the client hasn't received yet, and
+// whether msg needs to be sent at all.
+func (l sentLines) unsent(msg core.LogMessage) (string, bool) {
+	text := msg.RawText
+	if sent := l[msg.Stream]; strings.HasPrefix(text, sent) {
+		text = text[len(sent):]
+	}
+	if msg.Seq == 0 {
+		l[msg.Stream] = msg.RawText
+		return text, text != ""
+	}
+	delete(l, msg.Stream)
+	return text, true
+}
+
+func sendBatch(ws *websocket.Conn, batch []core.LogMessage, opts logStreamOptions, lines sentLines) error {
+	if opts.Format == "json" {
+		for _, msg := range batch {
+			text, ok := lines.unsent(msg)
+			if !ok {
+				continue
+			}
+			frame := logFrame{
+				Type:    "log",
+				Seq:     msg.Seq,
+				Stream:  msg.Stream,
+				Text:    text,
+				Partial: msg.Partial,
+				Time:    msg.Time,
+				Level:   msg.Level,
+			}
+			// A line whose beginning was already sent can't be replaced
+			// by a readable rendering
+			if text == msg.RawText && (msg.Level != "" || msg.Message != "" || !msg.LoggedAt.IsZero()) {
+				frame.Display = core.ReadableLog(msg)
+			}
+			if err := ws.WriteJSON(frame); err != nil {
+				return err
+			}
+			if event, ok := core.ParseCommand(msg); ok {
+				if err := ws.WriteJSON(eventFrame{Type: "event", JobEvent: event}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
 	var combined strings.Builder
 	for _, msg := range batch {
-		combined.WriteString(msg.RawText)
+		text, _ := lines.unsent(msg)
+		combined.WriteString(text)
+	}
+	if combined.Len() == 0 {
+		return nil
 	}
-	ws.WriteMessage(websocket.TextMessage, []byte(combined.String()))
+	return ws.WriteMessage(websocket.TextMessage, []byte(combined.String()))
 }
 
 func listJobsHandler(pm *core.ProcessManager) gin.HandlerFunc {
@@ -74,18 +304,7 @@ func listJobsHandler(pm *core.ProcessManager) gin.HandlerFunc {
 		if jobs != nil {
 			// Convert jobs to response format
 			for _, job := range jobs {
-				resp := gin.H{
-					"id":        job.ID,
-					"command":   job.Command,
-					"status":    job.Status,
-					"pid":       job.PID,
-					"startedAt": job.StartedAt.Format(time.RFC3339),
-				}
-				// Only include completedAt if it's not zero time
-				if !job.CompletedAt.IsZero() {
-					resp["completedAt"] = job.CompletedAt.Format(time.RFC3339)
-				}
-				response = append(response, resp)
+				response = append(response, jobResponse(job))
 			}
 		}
 
@@ -110,25 +329,50 @@ func getJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 			return
 		}
 
-		resp := gin.H{
-			"id":        job.ID,
-			"command":   job.Command,
-			"status":    job.Status,
-			"pid":       job.PID,
-			"startedAt": job.StartedAt.Format(time.RFC3339),
-		}
-		// Only include completedAt if it's not zero time
-		if !job.CompletedAt.IsZero() {
-			resp["completedAt"] = job.CompletedAt.Format(time.RFC3339)
-		}
-		c.JSON(http.StatusOK, resp)
+		c.JSON(http.StatusOK, jobResponse(job))
 	}
 }
 
+// StopJobRequest is the optional body of a stop request.
+type StopJobRequest struct {
+	Signal             string `json:"signal"`
+	GracePeriodSeconds int    `json:"gracePeriodSeconds"`
+}
+
 func stopJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		if err := pm.StopJob(id); err != nil {
+
+		// The body is optional, an empty one uses the server defaults
+		var req StopJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: " + err.Error(),
+			})
+			return
+		}
+		if req.GracePeriodSeconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: gracePeriodSeconds must not be negative",
+			})
+			return
+		}
+
+		opts := core.StopOptions{
+			GracePeriod: time.Duration(req.GracePeriodSeconds) * time.Second,
+		}
+		if req.Signal != "" {
+			sig, err := core.ParseSignal(req.Signal)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Invalid request: " + err.Error(),
+				})
+				return
+			}
+			opts.Signal = sig
+		}
+
+		if err := pm.StopJob(id, opts); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to stop job: " + err.Error(),
 			})
@@ -141,6 +385,44 @@ func stopJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 	}
 }
 
+// SignalJobRequest is the body of a signal request.
+type SignalJobRequest struct {
+	Signal string `json:"signal" binding:"required"`
+}
+
+func signalJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req SignalJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		sig, err := core.ParseSignal(req.Signal)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		if err := pm.SignalJob(id, sig); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to signal job: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Signal sent successfully",
+		})
+	}
+}
+
 func restartJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
@@ -152,14 +434,7 @@ func restartJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"id":          job.ID,
-			"command":     job.Command,
-			"status":      job.Status,
-			"pid":         job.PID,
-			"startedAt":   job.StartedAt.Format(time.RFC3339),
-			"completedAt": job.CompletedAt.Format(time.RFC3339),
-		})
+		c.JSON(http.StatusOK, jobResponse(job))
 	}
 }
 
@@ -178,6 +453,12 @@ func streamLogsHandler(pm *core.ProcessManager) gin.HandlerFunc {
 			return
 		}
 
+		opts, err := parseLogStreamOptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Upgrade to WebSocket connection
 		upgrader := websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -202,55 +483,152 @@ func streamLogsHandler(pm *core.ProcessManager) gin.HandlerFunc {
 		// Set WebSocket read deadline to prevent hanging connections
 		ws.SetReadDeadline(time.Now().Add(24 * time.Hour))
 
-		// Get historical logs first
-		logs, err := pm.Store.GetJobLogs(id)
+		// Get historical logs, and a subscription to real-time logs if
+		// the job is running, as one sequence
+		logs, sub, err := pm.SubscribeLogs(id, opts.Since)
 		if err != nil {
-			ws.WriteJSON(gin.H{"error": "Failed to get logs: " + err.Error()})
+			ws.WriteJSON(gin.H{"type": "error", "error": "Failed to get logs: " + err.Error()})
 			return
 		}
+		if sub != nil {
+			defer pm.Logs.Unsubscribe(sub)
+		}
 
 		// Send historical logs
+		last := opts.Since
+		lines := make(sentLines)
 		for _, log := range logs {
-			if err := ws.WriteMessage(websocket.TextMessage, []byte(log.RawText)); err != nil {
+			if log.Seq > 0 {
+				last = log.Seq
+			}
+			if !opts.matches(log) {
+				continue
+			}
+			if err := sendBatch(ws, []core.LogMessage{log}, opts, lines); err != nil {
 				return
 			}
 		}
 
-		// If job is running, subscribe to real-time logs
-		if job.Status == "running" {
-			// Create buffered channel for this client
-			clientChan := make(chan core.LogMessage, 1000)
-			defer close(clientChan)
-
-			// Start goroutine to forward messages from LogChan to client
-			go func() {
-				batch := make([]core.LogMessage, 0, 10)
-				ticker := time.NewTicker(10 * time.Millisecond)
-				defer ticker.Stop()
-
-				for {
-					select {
-					case msg := <-pm.LogChan:
-						if msg.JobID == id {
-							batch = append(batch, msg)
-							if len(batch) >= 10 {
-								sendBatch(ws, batch)
-								batch = batch[:0]
-							}
-						}
-					case <-ticker.C:
-						if len(batch) > 0 {
-							sendBatch(ws, batch)
-							batch = batch[:0]
-						}
-					case <-c.Done():
-						return
-					}
+		// Both the forwarder and the input handler write to the
+		// connection, which only supports one concurrent writer
+		var writeMu sync.Mutex
+		if sub == nil {
+			// The job has finished, there's nothing more to send
+			closeLogStream(ws, nil, opts, &writeMu)
+			return
+		}
+		go forwardLogs(ws, sub, last, lines, opts, &writeMu)
+
+		// Handle client input until the connection is closed
+		handleClientMessages(ws, pm, id, &writeMu)
+	}
+}
+
+// forwardLogs sends live log messages after sequence number last, and
+// previews, to the client in small batches. When the subscription ends,
+// because the job finished or the client couldn't keep up, the connection
+// is closed. A failed write closes it right away, which also ends the
+// handler reading from it.
+func forwardLogs(ws *websocket.Conn, sub *core.Subscription, last int64, lines sentLines, opts logStreamOptions, writeMu *sync.Mutex) {
+	batch := make([]core.LogMessage, 0, 10)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	// flush sends the batch and reports whether that succeeded
+	flush := func() bool {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		err := sendBatch(ws, batch, opts, lines)
+		batch = batch[:0]
+		if err != nil {
+			ws.Close()
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				if len(batch) > 0 && !flush() {
+					return
+				}
+				closeLogStream(ws, sub.Err(), opts, writeMu)
+				return
+			}
+			if (msg.Seq == 0 || msg.Seq > last) && opts.matches(msg) {
+				batch = append(batch, msg)
+				if len(batch) >= 10 && !flush() {
+					return
 				}
-			}()
+			}
+		case <-ticker.C:
+			if len(batch) > 0 && !flush() {
+				return
+			}
+		}
+	}
+}
+
+// closeLogStream starts the WebSocket closing handshake. The client's reply
+// ends the read loop, a deadline ensures it ends even without one.
+func closeLogStream(ws *websocket.Conn, err error, opts logStreamOptions, writeMu *sync.Mutex) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
 
-			// Wait for context done
-			<-c.Done()
+	code, text := websocket.CloseNormalClosure, "job finished"
+	if err != nil {
+		if opts.Format == "json" {
+			ws.WriteJSON(gin.H{"type": "error", "error": err.Error()})
+		}
+		code, text = websocket.CloseTryAgainLater, err.Error()
+	}
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+}
+
+// clientMessage is a message sent by a WebSocket client to the server.
+type clientMessage struct {
+	Type string `json:"type"` // "stdin", "eof" or "resize"
+	Data string `json:"data"` // Input for "stdin" messages
+	Cols uint16 `json:"cols"` // Terminal size for "resize" messages
+	Rows uint16 `json:"rows"`
+}
+
+// handleClientMessages reads messages from the client and applies them to
+// the job. It returns when the connection is closed.
+func handleClientMessages(ws *websocket.Conn, pm *core.ProcessManager, id string, writeMu *sync.Mutex) {
+	sendError := func(err error) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		ws.WriteJSON(gin.H{"type": "error", "error": err.Error()})
+	}
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			sendError(fmt.Errorf("invalid message: %w", err))
+			continue
+		}
+
+		switch msg.Type {
+		case "stdin":
+			err = pm.WriteStdin(id, []byte(msg.Data))
+		case "eof":
+			err = pm.CloseStdin(id)
+		case "resize":
+			err = pm.ResizeJob(id, msg.Cols, msg.Rows)
+		default:
+			err = fmt.Errorf("unknown message type: %q", msg.Type)
+		}
+		if err != nil {
+			sendError(err)
 		}
 	}
 }
@@ -265,8 +643,15 @@ func createJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 			return
 		}
 
-		// Start the job without timeout
-		job, err := pm.StartJob(req.Command)
+		spec := req.spec()
+		if err := spec.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		job, err := pm.StartJob(spec)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to start job: " + err.Error(),
@@ -275,17 +660,6 @@ func createJobHandler(pm *core.ProcessManager) gin.HandlerFunc {
 		}
 
 		// Return job information
-		resp := gin.H{
-			"id":        job.ID,
-			"command":   req.Command,
-			"status":    job.Status,
-			"pid":       job.PID,
-			"startedAt": job.StartedAt.Format(time.RFC3339),
-		}
-		// Only include completedAt if it's not zero time
-		if !job.CompletedAt.IsZero() {
-			resp["completedAt"] = job.CompletedAt.Format(time.RFC3339)
-		}
-		c.JSON(http.StatusCreated, resp)
+		c.JSON(http.StatusCreated, jobResponse(job))
 	}
 }
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"srun/internal/core"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestSentLines(t *testing.T) {
+	msgs := []struct {
+		msg  core.LogMessage
+		text string
+		send bool
+	}{
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "one\n", Seq: 1}, "one\n", true},
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "\r10%", Partial: true}, "\r10%", true},
+		{core.LogMessage{Stream: core.StreamStderr, RawText: "warn", Partial: true}, "warn", true},
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "\r10%\r50%", Partial: true}, "\r50%", true},
+		// A preview without new text isn't sent, the line always is
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "\r10%\r50%", Partial: true}, "", false},
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "\r10%\r50%\r100%\n", Seq: 2}, "\r100%\n", true},
+		{core.LogMessage{Stream: core.StreamStderr, RawText: "warn", Seq: 3}, "", true},
+		{core.LogMessage{Stream: core.StreamStdout, RawText: "two\n", Seq: 4}, "two\n", true},
+	}
+
+	lines := make(sentLines)
+	for i, m := range msgs {
+		text, send := lines.unsent(m.msg)
+		if text != m.text || send != m.send {
+			t.Errorf("message %d: got %q, %v, want %q, %v", i, text, send, m.text, m.send)
+		}
+	}
+}
+
+// newTestServer serves the API of a process manager backed by a new
+// database in a temporary directory.
+func newTestServer(t *testing.T) (*core.ProcessManager, *httptest.Server) {
+	t.Helper()
+	store, err := core.NewSQLiteStorage(filepath.Join(t.TempDir(), "srun.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := core.NewProcessManager(store)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupRoutes(r, pm)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return pm, srv
+}
+
+// dialLogs connects to the log stream of a job.
+func dialLogs(t *testing.T, srv *httptest.Server, id, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/jobs/" + id + "/logs?format=json&" + query
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return ws
+}
+
+// readLines reads log frames until n lines were received, and returns their
+// sequence numbers and text.
+func readLines(t *testing.T, ws *websocket.Conn, n int) (seqs []int64, text string) {
+	t.Helper()
+	for len(seqs) < n {
+		var frame logFrame
+		if err := ws.ReadJSON(&frame); err != nil {
+			t.Fatalf("after lines %v: %v", seqs, err)
+		}
+		if frame.Type != "log" {
+			continue
+		}
+		text += frame.Text
+		if frame.Seq > 0 {
+			seqs = append(seqs, frame.Seq)
+		}
+	}
+	return seqs, text
+}
+
+// waitForLines waits until a job printed n lines.
+func waitForLines(t *testing.T, pm *core.ProcessManager, id string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs, sub, err := pm.SubscribeLogs(id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub != nil {
+			pm.Logs.Unsubscribe(sub)
+		}
+		if len(logs) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job printed %d lines, want %d", len(logs), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForJob waits until a job finished and its output was stored.
+func waitForJob(t *testing.T, pm *core.ProcessManager, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := pm.Store.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != "running" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamLogsFilter(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: "echo out; echo err >&2; echo out again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, pm, job.ID)
+
+	for _, stream := range []string{core.StreamStdout, core.StreamStderr} {
+		ws := dialLogs(t, srv, job.ID, "stream="+stream)
+		var text string
+		for {
+			var frame logFrame
+			if err := ws.ReadJSON(&frame); err != nil {
+				break
+			}
+			if frame.Stream != stream {
+				t.Errorf("got %s output %q on the %s stream", frame.Stream, frame.Text, stream)
+			}
+			text += frame.Text
+		}
+		if want := map[string]string{core.StreamStdout: "out\nout again\n", core.StreamStderr: "err\n"}[stream]; text != want {
+			t.Errorf("got %s %q, want %q", stream, text, want)
+		}
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/api/jobs/" + job.ID + "/logs?stream=stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown stream, want 400", resp.StatusCode)
+	}
+}
+
+// waitForOutput waits until a job's stored output contains text.
+func waitForOutput(t *testing.T, pm *core.ProcessManager, id, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs, err := pm.Store.QueryJobLogs(core.LogQuery{JobID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, log := range logs {
+			out += log.RawText
+		}
+		if strings.Contains(out, text) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got output %q, want %q", out, text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignalJob(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: `trap 'echo reloaded' HUP; echo ready; while :; do sleep 0.1; done`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.StopJob(job.ID, core.StopOptions{Signal: syscall.SIGKILL})
+	waitForOutput(t, pm, job.ID, "ready\n")
+
+	signal := func(id, body string) int {
+		resp, err := srv.Client().Post(srv.URL+"/api/jobs/"+id+"/signal", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, body := range []string{`{}`, `{"signal": "SIGNOPE"}`, `{"signal": "0"}`, `not json`} {
+		if status := signal(job.ID, body); status != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", body, status, http.StatusBadRequest)
+		}
+	}
+	if status := signal("missing", `{"signal": "HUP"}`); status == http.StatusOK {
+		t.Errorf("signalling an unknown job succeeded")
+	}
+
+	if status := signal(job.ID, `{"signal": "HUP"}`); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	waitForOutput(t, pm, job.ID, "reloaded\n")
+	if err := pm.SignalJob(job.ID, syscall.SIGHUP); err != nil {
+		t.Errorf("job isn't running after the signal: %v", err)
+	}
+}
+
+func TestStreamLogsInput(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: `read name; echo "hello $name"; cat; echo done`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := dialLogs(t, srv, job.ID, "")
+	for _, msg := range []clientMessage{
+		{Type: "unknown"},
+		{Type: "stdin", Data: "srun\n"},
+		{Type: "stdin", Data: "more input\n"},
+		{Type: "eof"},
+	} {
+		if err := ws.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The input is echoed once cat reads it, and cat exits at the end of
+	// the input
+	var text string
+	var failures []string
+	for !strings.HasSuffix(text, "done\n") {
+		var frame struct {
+			Type  string `json:"type"`
+			Text  string `json:"text"`
+			Error string `json:"error"`
+		}
+		if err := ws.ReadJSON(&frame); err != nil {
+			t.Fatalf("after %q: %v", text, err)
+		}
+		switch frame.Type {
+		case "log":
+			text += frame.Text
+		case "error":
+			failures = append(failures, frame.Error)
+		}
+	}
+	if want := "hello srun\nmore input\ndone\n"; text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+	if len(failures) != 1 || !strings.Contains(failures[0], "unknown") {
+		t.Errorf("got failures %q, want one for the unknown message", failures)
+	}
+}
+
+func TestStreamLogsResume(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: "seq 1 5; read x; seq 6 8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForLines(t, pm, job.ID, 5)
+
+	ws := dialLogs(t, srv, job.ID, "since=0")
+	if seqs, text := readLines(t, ws, 5); !slices.Equal(seqs, []int64{1, 2, 3, 4, 5}) || text != "1\n2\n3\n4\n5\n" {
+		t.Errorf("got lines %v %q, want 1 to 5", seqs, text)
+	}
+	ws.Close()
+
+	// A client reconnecting after line 3 gets the rest of the history and
+	// then the live output as one sequence
+	ws = dialLogs(t, srv, job.ID, "since=3")
+	if err := ws.WriteJSON(clientMessage{Type: "stdin", Data: "\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, text := readLines(t, ws, 5); !slices.Equal(seqs, []int64{4, 5, 6, 7, 8}) || text != "4\n5\n6\n7\n8\n" {
+		t.Errorf("got lines %v %q, want 4 to 8", seqs, text)
+	}
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v, want the stream to end once the job finished", err)
+	}
+
+	// The history of the finished job resumes the same way
+	ws = dialLogs(t, srv, job.ID, "since=6")
+	if seqs, text := readLines(t, ws, 2); !slices.Equal(seqs, []int64{7, 8}) || text != "7\n8\n" {
+		t.Errorf("got lines %v %q, want 7 and 8", seqs, text)
+	}
+}
+
+func TestForwardLogsStopsOnWriteError(t *testing.T) {
+	broker := core.NewLogBroker(1000)
+	sub := broker.Subscribe("job")
+	defer broker.Unsubscribe(sub)
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var writeMu sync.Mutex
+		forwardLogs(ws, sub, 0, make(sentLines), logStreamOptions{Format: "text"}, &writeMu)
+		close(done)
+	}))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.NetConn().Close()
+
+	// Writes to the closed connection fail once the server notices
+	deadline := time.After(5 * time.Second)
+	for seq := int64(1); ; seq++ {
+		broker.Publish(core.LogMessage{JobID: "job", Seq: seq, Stream: core.StreamStdout, RawText: "line\n"})
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			t.Fatal("forwarding didn't stop after writes failed")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestStreamLogsResumeMidLine(t *testing.T) {
+	pm, srv := newTestServer(t)
+	job, err := pm.StartJob(core.JobSpec{Command: `echo one; printf 'Name: '; read name; echo "hello $name"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForLines(t, pm, job.ID, 2) // The first line and the prompt's preview
+
+	ws := dialLogs(t, srv, job.ID, "since=1")
+	var frame logFrame
+	if err := ws.ReadJSON(&frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Seq != 0 || frame.Text != "Name: " || !frame.Partial {
+		t.Errorf("got %+v, want a preview of the prompt", frame)
+	}
+	if err := ws.WriteJSON(clientMessage{Type: "stdin", Data: "srun\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, text := readLines(t, ws, 1); !slices.Equal(seqs, []int64{2}) || text != "hello srun\n" {
+		t.Errorf("got lines %v %q, want the rest of line 2", seqs, text)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"srun/internal/core"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSearchLimit is the maximum number of matching lines a search returns.
+const maxSearchLimit = 1000
+
+// searchMatch is the JSON representation of a log line matching a search.
+type searchMatch struct {
+	Seq     int64     `json:"seq"`
+	Stream  string    `json:"stream"`
+	Time    time.Time `json:"time"`
+	Snippet string    `json:"snippet"`
+}
+
+// parseSearchQuery builds a search query from the request's query
+// parameters.
+func parseSearchQuery(c *gin.Context) (core.SearchQuery, error) {
+	q := core.SearchQuery{
+		Query:  c.Query("q"),
+		Status: c.Query("status"),
+		Limit:  core.DefaultSearchLimit,
+	}
+	if strings.TrimSpace(q.Query) == "" {
+		return q, fmt.Errorf("search query must not be empty")
+	}
+	switch q.Status {
+	case "", "running", "stopped", "completed", "failed", "timeout", "lost":
+	default:
+		return q, fmt.Errorf("invalid status %q", q.Status)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return q, fmt.Errorf("invalid limit %q, expected a number from 1 to %d", limit, maxSearchLimit)
+		}
+		q.Limit = n
+	}
+	for name, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", name, value)
+		}
+		*t = parsed
+	}
+	return q, nil
+}
+
+// searchHandler searches the logs of all jobs and returns the matching
+// lines grouped by job, jobs with the best matches first.
+func searchHandler(pm *core.ProcessManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := parseSearchQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		matches, err := pm.SearchLogs(q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search logs: " + err.Error()})
+			return
+		}
+
+		results := make([]gin.H, 0)
+		byJob := make(map[string]int)
+		for _, m := range matches {
+			i, seen := byJob[m.JobID]
+			if !seen {
+				job, err := pm.GetJob(m.JobID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+					return
+				}
+				if job == nil {
+					// Removed since the search
+					continue
+				}
+				i = len(results)
+				byJob[m.JobID] = i
+				results = append(results, gin.H{
+					"job":     jobResponse(job),
+					"matches": []searchMatch{},
+				})
+			}
+			results[i]["matches"] = append(results[i]["matches"].([]searchMatch), searchMatch{
+				Seq:     m.Seq,
+				Stream:  m.Stream,
+				Time:    m.Time,
+				Snippet: m.Snippet,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
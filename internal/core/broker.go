@@ -0,0 +1,118 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultSubscriberBuffer is the number of messages buffered for each log
+// subscriber.
+const DefaultSubscriberBuffer = 1000
+
+// ErrSlowConsumer is reported by a subscription that was dropped because
+// its buffer was full.
+var ErrSlowConsumer = errors.New("log subscriber is too slow, messages were dropped")
+
+// LogBroker fans out log messages to subscribers of the job they belong to.
+//
+// Publishing never blocks. Each subscriber has a bounded buffer, and a
+// subscriber whose buffer is full is dropped rather than skipping messages
+// or slowing down the job: its channel is closed and Err returns
+// ErrSlowConsumer, so the client can reconnect and catch up from storage.
+type LogBroker struct {
+	mu         sync.Mutex
+	subs       map[string]map[*Subscription]struct{}
+	bufferSize int
+}
+
+// Subscription receives the log messages of one job.
+type Subscription struct {
+	JobID string
+	// C delivers messages in the order they were published. It is closed
+	// when the subscription ends.
+	C <-chan LogMessage
+
+	ch  chan LogMessage
+	err error
+}
+
+// Err returns ErrSlowConsumer if the subscription was dropped, or nil if it
+// ended because it was unsubscribed or the job finished. It must only be
+// called after C was closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func NewLogBroker(bufferSize int) *LogBroker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriberBuffer
+	}
+	return &LogBroker{
+		subs:       make(map[string]map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscribe registers a subscriber for the messages of a job.
+func (b *LogBroker) Subscribe(jobID string) *Subscription {
+	ch := make(chan LogMessage, b.bufferSize)
+	sub := &Subscription{JobID: jobID, C: ch, ch: ch}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[jobID] == nil {
+		b.subs[jobID] = make(map[*Subscription]struct{})
+	}
+	b.subs[jobID][sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe ends a subscription. It is safe to call more than once and
+// after the subscription was already closed by the broker.
+func (b *LogBroker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub, nil)
+}
+
+// Publish delivers a message to the subscribers of its job.
+func (b *LogBroker) Publish(msg LogMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[msg.JobID] {
+		select {
+		case sub.ch <- msg:
+		default:
+			b.remove(sub, ErrSlowConsumer)
+		}
+	}
+}
+
+// CloseJob ends all subscriptions of a job, once it has no more output.
+func (b *LogBroker) CloseJob(jobID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[jobID] {
+		b.remove(sub, nil)
+	}
+}
+
+// remove closes a subscription. The caller must hold b.mu.
+func (b *LogBroker) remove(sub *Subscription, err error) {
+	subs, ok := b.subs[sub.JobID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subs, sub.JobID)
+	}
+	sub.err = err
+	close(sub.ch)
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+// receive returns the sequence numbers of the messages buffered for a
+// subscription, and whether it was closed.
+func receive(sub *Subscription) (seqs []int64, closed bool) {
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return seqs, true
+			}
+			seqs = append(seqs, msg.Seq)
+		default:
+			return seqs, false
+		}
+	}
+}
+
+func TestLogBrokerPublish(t *testing.T) {
+	b := NewLogBroker(10)
+	first, second := b.Subscribe("a"), b.Subscribe("a")
+	other := b.Subscribe("b")
+	for seq := int64(1); seq <= 3; seq++ {
+		b.Publish(LogMessage{JobID: "a", Seq: seq})
+	}
+
+	for i, sub := range []*Subscription{first, second} {
+		if seqs, closed := receive(sub); len(seqs) != 3 || seqs[0] != 1 || seqs[2] != 3 || closed {
+			t.Errorf("subscriber %d got %v, closed %v, want [1 2 3] and open", i, seqs, closed)
+		}
+		if cap(sub.C) != 10 {
+			t.Errorf("subscriber %d buffers %d messages, want 10", i, cap(sub.C))
+		}
+	}
+	if seqs, _ := receive(other); len(seqs) != 0 {
+		t.Errorf("subscriber of another job got %v", seqs)
+	}
+}
+
+func TestLogBrokerDropsSlowSubscribers(t *testing.T) {
+	b := NewLogBroker(2)
+	slow, fast := b.Subscribe("job"), b.Subscribe("job")
+	var got []int64
+	for seq := int64(1); seq <= 5; seq++ {
+		// Publishing never blocks on the slow subscriber
+		b.Publish(LogMessage{JobID: "job", Seq: seq})
+		seqs, _ := receive(fast)
+		got = append(got, seqs...)
+	}
+
+	// The slow subscriber keeps what it was sent before it was dropped
+	seqs, closed := receive(slow)
+	if len(seqs) != 2 || !closed || slow.Err() != ErrSlowConsumer {
+		t.Errorf("slow subscriber got %v, closed %v, error %v, want 2 messages and ErrSlowConsumer", seqs, closed, slow.Err())
+	}
+	if len(got) != 5 {
+		t.Errorf("fast subscriber got %v, want all 5 messages", got)
+	}
+	if _, closed := receive(fast); closed {
+		t.Error("fast subscriber was dropped")
+	}
+}
+
+func TestLogBrokerUnsubscribeDuringPublish(t *testing.T) {
+	b := NewLogBroker(4)
+	done := make(chan struct{})
+	var publisher sync.WaitGroup
+	publisher.Add(1)
+	go func() {
+		defer publisher.Done()
+		for seq := int64(1); ; seq++ {
+			select {
+			case <-done:
+				return
+			default:
+				b.Publish(LogMessage{JobID: "job", Seq: seq})
+			}
+		}
+	}()
+
+	// Subscribers come and go while messages are published, some of them
+	// after falling behind and being dropped
+	var subscribers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		subscribers.Add(1)
+		go func() {
+			defer subscribers.Done()
+			for j := 0; j < 20; j++ {
+				sub := b.Subscribe("job")
+				last := int64(0)
+				for k := 0; k < j%5; k++ {
+					msg, ok := <-sub.C
+					if !ok {
+						break
+					}
+					if msg.Seq <= last {
+						t.Errorf("got message %d after %d", msg.Seq, last)
+					}
+					last = msg.Seq
+				}
+				b.Unsubscribe(sub)
+				b.Unsubscribe(sub)
+				if _, closed := receive(sub); !closed {
+					t.Error("subscription is still open after unsubscribing")
+				}
+			}
+		}()
+	}
+	subscribers.Wait()
+	close(done)
+	publisher.Wait()
+}
+
+func TestLogBrokerCloseJob(t *testing.T) {
+	b := NewLogBroker(10)
+	sub := b.Subscribe("job")
+	b.Publish(LogMessage{JobID: "job", Seq: 1})
+	b.Publish(LogMessage{JobID: "job", Seq: 2})
+	b.CloseJob("job")
+
+	// Messages published before the job finished are still delivered
+	seqs, closed := receive(sub)
+	if len(seqs) != 2 || !closed || sub.Err() != nil {
+		t.Errorf("got %v, closed %v, error %v, want 2 messages and a normal close", seqs, closed, sub.Err())
+	}
+
+	// Publishing to the closed subscription and ending it again are
+	// harmless
+	b.Publish(LogMessage{JobID: "job", Seq: 3})
+	b.Unsubscribe(sub)
+	b.CloseJob("job")
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Jobs report structured information by printing workflow commands, lines
+// starting with "::" like "::progress 42/100". The commands stay in the log
+// and are collected into the job's metadata.
+
+// Limits on how much a job's metadata collects, later commands are ignored.
+const (
+	maxOutputs     = 1000
+	maxAnnotations = 1000
+	maxGroups      = 1000
+)
+
+// JobMetadata is the information a job has reported through workflow
+// commands.
+type JobMetadata struct {
+	Progress    *float64          `json:"progress,omitempty"` // Percent complete
+	Outputs     map[string]string `json:"outputs,omitempty"`
+	Annotations []Annotation      `json:"annotations,omitempty"`
+	Groups      []LogGroup        `json:"groups,omitempty"`
+}
+
+// Annotation is an error, warning or notice reported by a job, optionally
+// pointing at a location in a file.
+type Annotation struct {
+	Seq     int64  `json:"seq"`   // Log line of the command
+	Level   string `json:"level"` // error, warning or notice
+	Message string `json:"message"`
+	Title   string `json:"title,omitempty"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Col     int    `json:"col,omitempty"`
+}
+
+// LogGroup is a collapsible section of a job's log.
+type LogGroup struct {
+	Title    string `json:"title"`
+	StartSeq int64  `json:"startSeq"`         // Log line of the ::group:: command
+	EndSeq   int64  `json:"endSeq,omitempty"` // Log line of the ::endgroup:: command, 0 while open
+}
+
+// JobEvent is a workflow command parsed from a line of a job's output.
+type JobEvent struct {
+	Seq        int64       `json:"seq"`
+	Kind       string      `json:"event"` // progress, output, annotation, group or endgroup
+	Progress   *float64    `json:"progress,omitempty"`
+	Key        string      `json:"key,omitempty"`
+	Value      string      `json:"value,omitempty"`
+	Annotation *Annotation `json:"annotation,omitempty"`
+	Title      string      `json:"title,omitempty"`
+}
+
+// ParseCommand parses the workflow command on a log line, if it has one:
+//
+//	::progress 42/100            or 42%
+//	::set-output key=value
+//	::error file=x,line=3::msg   also warning and notice, properties are optional
+//	::group::title
+//	::endgroup::
+func ParseCommand(msg LogMessage) (JobEvent, bool) {
+	if msg.Partial || !strings.HasPrefix(msg.Text, "::") {
+		return JobEvent{}, false
+	}
+	name, rest := msg.Text[2:], ""
+	if i := strings.IndexAny(name, " :"); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	event := JobEvent{Seq: msg.Seq}
+
+	switch name {
+	case "progress":
+		progress, ok := parseProgress(strings.TrimSpace(rest))
+		if !ok || !strings.HasPrefix(rest, " ") {
+			return event, false
+		}
+		event.Kind, event.Progress = "progress", &progress
+	case "set-output":
+		key, value, ok := strings.Cut(strings.TrimSpace(rest), "=")
+		if !ok || key == "" || !strings.HasPrefix(rest, " ") {
+			return event, false
+		}
+		event.Kind, event.Key, event.Value = "output", key, value
+	case "error", "warning", "notice":
+		props, message, ok := strings.Cut(rest, "::")
+		if !ok {
+			return event, false
+		}
+		annotation := &Annotation{Seq: msg.Seq, Level: name, Message: message}
+		for _, prop := range strings.FieldsFunc(props, func(r rune) bool { return r == ',' || r == ' ' }) {
+			key, value, _ := strings.Cut(prop, "=")
+			switch key {
+			case "title":
+				annotation.Title = value
+			case "file":
+				annotation.File = value
+			case "line":
+				annotation.Line, _ = strconv.Atoi(value)
+			case "col":
+				annotation.Col, _ = strconv.Atoi(value)
+			}
+		}
+		event.Kind, event.Annotation = "annotation", annotation
+	case "group":
+		if !strings.HasPrefix(rest, "::") {
+			return event, false
+		}
+		event.Kind, event.Title = "group", rest[2:]
+	case "endgroup":
+		if rest != "::" {
+			return event, false
+		}
+		event.Kind = "endgroup"
+	default:
+		return event, false
+	}
+	return event, true
+}
+
+// parseProgress parses a progress value, either done/total or a percentage,
+// into percent complete.
+func parseProgress(s string) (float64, bool) {
+	var percent float64
+	if done, total, ok := strings.Cut(s, "/"); ok {
+		d, err1 := strconv.ParseFloat(done, 64)
+		t, err2 := strconv.ParseFloat(total, 64)
+		if err1 != nil || err2 != nil || t <= 0 {
+			return 0, false
+		}
+		percent = d / t * 100
+	} else {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, false
+		}
+		percent = p
+	}
+	return max(0, min(percent, 100)), true
+}
+
+// Metadata returns what the job has reported through workflow commands, or
+// nil if it hasn't reported anything.
+func (j *Job) Metadata() *JobMetadata {
+	return j.metadata.Load()
+}
+
+// applyEvent records a workflow command in the job's metadata. The metadata
+// is replaced rather than modified, so readers don't need a lock. Only one
+// event may be applied at a time.
+func (j *Job) applyEvent(event JobEvent) {
+	var m JobMetadata
+	if current := j.metadata.Load(); current != nil {
+		m = *current
+	}
+
+	switch event.Kind {
+	case "progress":
+		m.Progress = event.Progress
+	case "output":
+		if _, exists := m.Outputs[event.Key]; !exists && len(m.Outputs) >= maxOutputs {
+			return
+		}
+		outputs := make(map[string]string, len(m.Outputs)+1)
+		for key, value := range m.Outputs {
+			outputs[key] = value
+		}
+		outputs[event.Key] = event.Value
+		m.Outputs = outputs
+	case "annotation":
+		if len(m.Annotations) >= maxAnnotations {
+			return
+		}
+		// Earlier versions only see their own part of the slice
+		m.Annotations = append(m.Annotations, *event.Annotation)
+	case "group":
+		if len(m.Groups) >= maxGroups {
+			return
+		}
+		m.Groups = append(m.Groups, LogGroup{Title: event.Title, StartSeq: event.Seq})
+	case "endgroup":
+		// Ends the innermost open group
+		i := len(m.Groups) - 1
+		for i >= 0 && m.Groups[i].EndSeq != 0 {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+		m.Groups = append([]LogGroup(nil), m.Groups...)
+		m.Groups[i].EndSeq = event.Seq
+	}
+	j.metadata.Store(&m)
+}
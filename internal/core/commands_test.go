@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	progress := func(p float64) *float64 { return &p }
+	tests := []struct {
+		text string
+		want *JobEvent // Nil if the line isn't a command
+	}{
+		{"::progress 42/100", &JobEvent{Seq: 7, Kind: "progress", Progress: progress(42)}},
+		{"::progress 12.5%", &JobEvent{Seq: 7, Kind: "progress", Progress: progress(12.5)}},
+		{"::progress 150%", &JobEvent{Seq: 7, Kind: "progress", Progress: progress(100)}},
+		{"::set-output url=http://host/?a=b", &JobEvent{Seq: 7, Kind: "output", Key: "url", Value: "http://host/?a=b"}},
+		{"::set-output empty=", &JobEvent{Seq: 7, Kind: "output", Key: "empty"}},
+		{"::error file=main.go,line=3,col=5,title=Build::undefined: x", &JobEvent{Seq: 7, Kind: "annotation",
+			Annotation: &Annotation{Seq: 7, Level: "error", Message: "undefined: x", Title: "Build", File: "main.go", Line: 3, Col: 5}}},
+		{"::warning::deprecated", &JobEvent{Seq: 7, Kind: "annotation", Annotation: &Annotation{Seq: 7, Level: "warning", Message: "deprecated"}}},
+		{"::notice line=x::bad line", &JobEvent{Seq: 7, Kind: "annotation", Annotation: &Annotation{Seq: 7, Level: "notice", Message: "bad line"}}},
+		{"::group::Tests", &JobEvent{Seq: 7, Kind: "group", Title: "Tests"}},
+		{"::endgroup::", &JobEvent{Seq: 7, Kind: "endgroup"}},
+
+		{"progress 42%", nil},
+		{"::progress", nil},
+		{"::progress42", nil},
+		{"::progress 1/0", nil},
+		{"::progress lots", nil},
+		{"::set-output key", nil},
+		{"::set-output =value", nil},
+		{"::error missing message", nil},
+		{"::group title", nil},
+		{"::endgroup:: now", nil},
+		{"::unknown::command", nil},
+	}
+	for _, tt := range tests {
+		event, ok := ParseCommand(LogMessage{Seq: 7, Text: tt.text})
+		switch {
+		case tt.want == nil && ok:
+			t.Errorf("ParseCommand(%q) = %+v, want no command", tt.text, event)
+		case tt.want != nil && !ok:
+			t.Errorf("ParseCommand(%q) found no command, want %+v", tt.text, *tt.want)
+		case tt.want != nil && !reflect.DeepEqual(event, *tt.want):
+			t.Errorf("ParseCommand(%q) = %+v, want %+v", tt.text, event, *tt.want)
+		}
+	}
+
+	if _, ok := ParseCommand(LogMessage{Text: "::progress 50%", Partial: true}); ok {
+		t.Error("parsed a command from a partial line")
+	}
+}
+
+func TestApplyEvent(t *testing.T) {
+	var job Job
+	if job.Metadata() != nil {
+		t.Fatal("new job has metadata")
+	}
+
+	lines := []string{
+		"::progress 10%",
+		"::set-output a=1",
+		"::group::Build",
+		"::group::Compile",
+		"::warning::slow",
+		"::endgroup::",
+		"::set-output a=2",
+		"::progress 3/4",
+		"::endgroup::",
+		"::endgroup::", // Nothing left to end
+	}
+	var seen []*JobMetadata
+	for i, line := range lines {
+		event, ok := ParseCommand(LogMessage{Seq: int64(i + 1), Text: line})
+		if !ok {
+			t.Fatalf("%q is not a command", line)
+		}
+		job.applyEvent(event)
+		seen = append(seen, job.Metadata())
+	}
+
+	progress := 75.0
+	want := &JobMetadata{
+		Progress:    &progress,
+		Outputs:     map[string]string{"a": "2"},
+		Annotations: []Annotation{{Seq: 5, Level: "warning", Message: "slow"}},
+		Groups:      []LogGroup{{Title: "Build", StartSeq: 3, EndSeq: 9}, {Title: "Compile", StartSeq: 4, EndSeq: 6}},
+	}
+	if got := job.Metadata(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got metadata %+v, want %+v", got, want)
+	}
+
+	// Metadata read earlier isn't changed by later commands
+	if got := seen[1].Outputs["a"]; got != "1" {
+		t.Errorf("earlier metadata has output a=%q, want 1", got)
+	}
+	if got := seen[3].Groups[1].EndSeq; got != 0 {
+		t.Errorf("earlier metadata has the group ending at %d, want it open", got)
+	}
+}
+
+func TestApplyEventLimits(t *testing.T) {
+	var job Job
+	for i := range maxOutputs + 10 {
+		job.applyEvent(JobEvent{Kind: "output", Key: fmt.Sprintf("key%d", i), Value: "x"})
+	}
+	job.applyEvent(JobEvent{Kind: "output", Key: "key0", Value: "changed"})
+	m := job.Metadata()
+	if len(m.Outputs) != maxOutputs {
+		t.Errorf("got %d outputs, want %d", len(m.Outputs), maxOutputs)
+	}
+	if m.Outputs["key0"] != "changed" {
+		t.Error("existing output was not updated once the limit was reached")
+	}
+}
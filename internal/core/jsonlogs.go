@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Jobs in JSON logs mode print one JSON object per line, as many services
+// do. The level, message and time of each line are stored alongside it.
+
+// LogLevels are the normalized levels of structured log lines, from least
+// to most severe.
+var LogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// levelAliases maps other common level names to normalized levels.
+var levelAliases = map[string]string{
+	"verbose":       "trace",
+	"dbg":           "debug",
+	"information":   "info",
+	"informational": "info",
+	"notice":        "info",
+	"warning":       "warn",
+	"err":           "error",
+	"critical":      "fatal",
+	"crit":          "fatal",
+	"alert":         "fatal",
+	"emerg":         "fatal",
+	"emergency":     "fatal",
+	"panic":         "fatal",
+	"dpanic":        "fatal",
+}
+
+// Fields holding the level, message and time of a JSON log line, in order
+// of preference.
+var (
+	levelFields   = []string{"level", "lvl", "severity"}
+	messageFields = []string{"msg", "message"}
+	timeFields    = []string{"time", "timestamp", "ts", "@timestamp"}
+)
+
+// ParseLogLevel normalizes the name of a log level.
+func ParseLogLevel(name string) (string, error) {
+	if level := normalizeLevel(name); level != "" {
+		return level, nil
+	}
+	return "", fmt.Errorf("invalid log level %q, expected one of %s", name, strings.Join(LogLevels, ", "))
+}
+
+// LevelAtLeast reports whether a log line's level is at least min. Lines
+// without a level never are.
+func LevelAtLeast(level, min string) bool {
+	return level != "" && levelRank(level) >= levelRank(min)
+}
+
+// levelsFrom returns the levels at least as severe as min.
+func levelsFrom(min string) []string {
+	return LogLevels[max(levelRank(min), 0):]
+}
+
+func levelRank(level string) int {
+	for i, l := range LogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+func normalizeLevel(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if levelRank(name) >= 0 {
+		return name
+	}
+	return levelAliases[name]
+}
+
+// parseJSONLog returns the fields of a JSON log line, and its level, message
+// and time if it has them. ok is false if the line isn't a JSON object.
+func parseJSONLog(text string) (fields map[string]json.RawMessage, level, message string, t time.Time, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") {
+		return nil, "", "", time.Time{}, false
+	}
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return nil, "", "", time.Time{}, false
+	}
+
+	if _, value := field(fields, levelFields); value != nil {
+		var name string
+		var number float64
+		if json.Unmarshal(value, &name) == nil {
+			level = normalizeLevel(name)
+		} else if json.Unmarshal(value, &number) == nil {
+			level = numericLevel(number)
+		}
+	}
+	if _, value := field(fields, messageFields); value != nil {
+		if json.Unmarshal(value, &message) != nil {
+			message = string(value)
+		}
+	}
+	if _, value := field(fields, timeFields); value != nil {
+		t = parseLogTime(value)
+	}
+	return fields, level, message, t, true
+}
+
+// field returns the first of the given fields that the log line has.
+func field(fields map[string]json.RawMessage, names []string) (string, json.RawMessage) {
+	for _, name := range names {
+		if value, ok := fields[name]; ok {
+			return name, value
+		}
+	}
+	return "", nil
+}
+
+// numericLevel converts a numeric level, as logged by pino and bunyan.
+func numericLevel(n float64) string {
+	switch {
+	case n <= 10:
+		return "trace"
+	case n <= 20:
+		return "debug"
+	case n <= 30:
+		return "info"
+	case n <= 40:
+		return "warn"
+	case n <= 50:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
+
+// parseLogTime parses an RFC 3339 time, or a Unix time in seconds,
+// milliseconds, microseconds or nanoseconds.
+func parseLogTime(value json.RawMessage) time.Time {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+	var n float64
+	if json.Unmarshal(value, &n) != nil || n <= 0 {
+		return time.Time{}
+	}
+	switch {
+	case n < 1e11:
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	case n < 1e14:
+		return time.UnixMilli(int64(n))
+	case n < 1e17:
+		return time.UnixMicro(int64(n))
+	default:
+		return time.Unix(0, int64(n))
+	}
+}
+
+// levelColors are the SGR codes of levels in readable log lines.
+var levelColors = map[string]string{
+	"trace": "90",
+	"debug": "90",
+	"info":  "36",
+	"warn":  "33",
+	"error": "31",
+	"fatal": "1;31",
+}
+
+// ReadableLog renders a JSON log line for a terminal: its time, colored
+// level and message, followed by its other fields as key=value. Other
+// lines are returned as they are.
+func ReadableLog(msg LogMessage) string {
+	fields, level, message, t, ok := parseJSONLog(msg.Text)
+	if !ok {
+		return msg.RawText
+	}
+
+	var b strings.Builder
+	if !t.IsZero() {
+		b.WriteString("\x1b[2m" + t.Format("2006-01-02T15:04:05.000Z07:00") + "\x1b[0m ")
+	}
+	if level != "" {
+		fmt.Fprintf(&b, "\x1b[%sm%-5s\x1b[0m ", levelColors[level], strings.ToUpper(level))
+	}
+	b.WriteString(message)
+
+	used := map[string]bool{}
+	for _, names := range [][]string{levelFields, messageFields, timeFields} {
+		if name, _ := field(fields, names); name != "" {
+			used[name] = true
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if !used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " \x1b[2m%s=\x1b[0m%s", key, fieldValue(fields[key]))
+	}
+
+	if strings.HasSuffix(msg.RawText, "\n") {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// fieldValue formats a field of a JSON log line: strings without spaces or
+// quotes as they are, anything else as compact JSON.
+func fieldValue(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil && s != "" && !strings.ContainsAny(s, " \t\r\n\"=") {
+		return s
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, value) != nil {
+		return string(value)
+	}
+	return compact.String()
+}
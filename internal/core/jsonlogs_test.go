@@ -0,0 +1,116 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseJSONLog(t *testing.T) {
+	tests := []struct {
+		text           string
+		ok             bool
+		level, message string
+		time           time.Time
+	}{
+		{`{"level":"INFO","msg":"started","time":"2024-05-01T10:00:00.5Z"}`, true, "info", "started", time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC)},
+		{`{"lvl":"warning","message":"disk","ts":1714557600}`, true, "warn", "disk", time.Unix(1714557600, 0)},
+		{`{"level":50,"msg":"failed","time":1714557600123}`, true, "error", "failed", time.UnixMilli(1714557600123)},
+		{`{"severity":"crit","msg":{"code":3}}`, true, "fatal", `{"code":3}`, time.Time{}},
+		{`  {"level":"loud","msg":"odd level"}  `, true, "", "odd level", time.Time{}},
+		{`{}`, true, "", "", time.Time{}},
+		{`not json`, false, "", "", time.Time{}},
+		{`{"truncated":`, false, "", "", time.Time{}},
+		{`["array"]`, false, "", "", time.Time{}},
+	}
+	for _, tt := range tests {
+		_, level, message, logged, ok := parseJSONLog(tt.text)
+		if ok != tt.ok || level != tt.level || message != tt.message || !logged.Equal(tt.time) {
+			t.Errorf("parseJSONLog(%s) = %q, %q, %v, %v; want %q, %q, %v, %v",
+				tt.text, level, message, logged, ok, tt.level, tt.message, tt.time, tt.ok)
+		}
+	}
+}
+
+func TestNumericLevel(t *testing.T) {
+	tests := map[float64]string{
+		10: "trace", 20: "debug", 30: "info", 35: "warn", 40: "warn", 50: "error", 60: "fatal",
+	}
+	for n, want := range tests {
+		if got := numericLevel(n); got != want {
+			t.Errorf("numericLevel(%v) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestParseLogTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{`"2024-05-01T10:00:00Z"`, want},
+		{`"2024-05-01T12:00:00+02:00"`, want},
+		{`1714557600`, want},
+		{`1714557600.25`, want.Add(250 * time.Millisecond)},
+		{`1714557600000`, want},
+		{`1714557600000000`, want},
+		{`1714557600000000000`, want},
+		{`"yesterday"`, time.Time{}},
+		{`0`, time.Time{}},
+		{`true`, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseLogTime(json.RawMessage(tt.value)); !got.Equal(tt.want) {
+			t.Errorf("parseLogTime(%s) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, want := range map[string]string{"debug": "debug", " WARNING ": "warn", "Err": "error", "emerg": "fatal"} {
+		if got, err := ParseLogLevel(name); err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Error("ParseLogLevel accepted an unknown level")
+	}
+}
+
+func TestLevelAtLeast(t *testing.T) {
+	tests := []struct {
+		level, min string
+		want       bool
+	}{
+		{"error", "warn", true},
+		{"warn", "warn", true},
+		{"info", "warn", false},
+		{"", "trace", false},
+	}
+	for _, tt := range tests {
+		if got := LevelAtLeast(tt.level, tt.min); got != tt.want {
+			t.Errorf("LevelAtLeast(%q, %q) = %v, want %v", tt.level, tt.min, got, tt.want)
+		}
+	}
+	if got := levelsFrom("error"); len(got) != 2 || got[0] != "error" {
+		t.Errorf("levelsFrom(error) = %q, want error and fatal", got)
+	}
+}
+
+func TestReadableLog(t *testing.T) {
+	msg := LogMessage{
+		Text:    `{"level":"error","msg":"failed","time":"2024-05-01T10:00:00Z","user":"bob","attempt":3,"err":"no route"}`,
+		RawText: `{"level":"error","msg":"failed","time":"2024-05-01T10:00:00Z","user":"bob","attempt":3,"err":"no route"}` + "\n",
+	}
+	want := "\x1b[2m2024-05-01T10:00:00.000Z\x1b[0m \x1b[31mERROR\x1b[0m failed" +
+		" \x1b[2mattempt=\x1b[0m3 \x1b[2merr=\x1b[0m\"no route\" \x1b[2muser=\x1b[0mbob\n"
+	if got := ReadableLog(msg); got != want {
+		t.Errorf("ReadableLog = %q, want %q", got, want)
+	}
+
+	plain := LogMessage{Text: "plain", RawText: "\x1b[1mplain\x1b[0m\n"}
+	if got := ReadableLog(plain); got != plain.RawText {
+		t.Errorf("ReadableLog of a plain line = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"srun/internal/ansi"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxLineLength is the maximum size in bytes of a single log line. Longer
+// lines are split into several messages, all but the last marked partial.
+const MaxLineLength = 16 * 1024
+
+// partialLineDelay is how long an unterminated line, like a prompt or a
+// progress bar, is held back before it's emitted as a partial line.
+const partialLineDelay = 100 * time.Millisecond
+
+// maxHoldbackDelay limits how long the end of an unterminated line that may
+// be the start of a secret is held back. A prompt like "Token: bearer " is
+// then shown anyway, at the risk of missing a secret whose rest arrives
+// later.
+const maxHoldbackDelay = time.Second
+
+// lineSplitter splits an output stream into lines. Lines keep their
+// terminating newline, so concatenating them reproduces the stream. Secrets
+// are redacted from the lines, which are assembled first, so secrets split
+// across reads are still found.
+type lineSplitter struct {
+	mu      sync.Mutex
+	buf     []byte
+	started time.Time // When the first byte of buf was read
+	held    time.Time // When the end of buf was first held back, zero if it isn't
+	timer   *time.Timer
+	redact  *redactor // Nil if there is nothing to redact
+	emit    func(raw string, partial bool, t time.Time)
+}
+
+func newLineSplitter(redact *redactor, emit func(raw string, partial bool, t time.Time)) *lineSplitter {
+	return &lineSplitter{redact: redact, emit: emit}
+}
+
+// Write emits the complete lines in p, and buffers the rest until the line
+// is terminated or partialLineDelay has passed.
+func (s *lineSplitter) Write(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for len(p) > 0 {
+		if len(s.buf) == 0 {
+			s.started = now
+		}
+
+		n, complete := len(p), false
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			n, complete = i+1, true
+		}
+		if room := MaxLineLength - len(s.buf); n > room {
+			n, complete = room, false
+		}
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+
+		if complete {
+			s.flush(len(s.buf), false)
+		} else if len(s.buf) >= MaxLineLength {
+			s.flush(s.partialLength(), true)
+		}
+	}
+
+	if len(s.buf) > 0 && s.timer == nil {
+		s.timer = time.AfterFunc(partialLineDelay, s.flushPartial)
+	}
+}
+
+// Close emits the buffered remainder of the stream.
+func (s *lineSplitter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 {
+		s.flush(len(s.buf), false)
+	}
+}
+
+func (s *lineSplitter) flushPartial() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = nil
+	n := s.partialLength()
+	if !s.held.IsZero() && time.Since(s.held) >= maxHoldbackDelay {
+		n = completeRunes(s.buf)
+	}
+	if n > 0 {
+		s.flush(n, true)
+	}
+	// Wait for the rest of a multi-byte character or secret
+	if len(s.buf) > 0 {
+		if s.held.IsZero() {
+			s.held = time.Now()
+		}
+		s.timer = time.AfterFunc(partialLineDelay, s.flushPartial)
+	}
+}
+
+// partialLength returns how much of the buffer can be emitted as a partial
+// line: whole characters, up to what may be the start of a secret. The
+// caller must hold s.mu.
+func (s *lineSplitter) partialLength() int {
+	n := completeRunes(s.buf)
+	if s.redact != nil {
+		n -= s.redact.holdback(string(s.buf[:n]))
+	}
+	return n
+}
+
+// flush emits the first n bytes of the buffer. The caller must hold s.mu.
+func (s *lineSplitter) flush(n int, partial bool) {
+	raw := string(s.buf[:n])
+	if s.redact != nil {
+		raw = s.redact.redact(raw)
+	}
+	s.emit(raw, partial, s.started)
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+	s.held = time.Time{}
+	if len(s.buf) == 0 && s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// completeRunes returns the length of the longest prefix of b that doesn't
+// end in the middle of a UTF-8 encoded character.
+func completeRunes(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// lineText returns the plain text of a log line, without ANSI codes and
+// the line terminator.
+func lineText(raw string) string {
+	return ansi.Process(strings.TrimSuffix(raw, "\n")).Plain
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitLines feeds parts to a lineSplitter, flushing partial lines after
+// each part like the timer would, and returns the emitted lines with a "~"
+// prefix marking partial ones.
+func splitLines(parts ...string) []string {
+	var lines []string
+	s := newLineSplitter(nil, func(raw string, partial bool, t time.Time) {
+		if partial {
+			raw = "~" + raw
+		}
+		lines = append(lines, raw)
+	})
+	for _, part := range parts {
+		s.Write([]byte(part))
+		s.flushPartial()
+	}
+	s.Close()
+	return lines
+}
+
+func TestLineSplitter(t *testing.T) {
+	long := strings.Repeat("x", MaxLineLength)
+	tests := []struct {
+		name  string
+		parts []string
+		want  []string
+	}{
+		{"lines", []string{"one\ntwo\n"}, []string{"one\n", "two\n"}},
+		{"empty lines", []string{"\n\n"}, []string{"\n", "\n"}},
+		{"line across reads", []string{"o", "ne\n"}, []string{"~o", "ne\n"}},
+		{"unterminated end", []string{"one\ntwo"}, []string{"one\n", "~two"}},
+		{"progress bar", []string{"\r10%", "\r100%\n"}, []string{"~\r10%", "\r100%\n"}},
+		{"long line", []string{long + "y\n"}, []string{"~" + long, "y\n"}},
+		{"line of maximum length", []string{long[1:] + "\n"}, []string{long[1:] + "\n"}},
+		{"character across reads", []string{"caf\xc3", "\xa9\n"}, []string{"~caf", "é\n"}},
+		{"character across the length limit", []string{long[1:] + "é\n"}, []string{"~" + long[1:], "é\n"}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.parts...); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, shorten(got), shorten(tt.want))
+		}
+	}
+}
+
+// shorten quotes lines for error messages, abbreviating long ones.
+func shorten(lines []string) string {
+	var quoted []string
+	for _, line := range lines {
+		if len(line) > 40 {
+			line = fmt.Sprintf("%s...(%d bytes)...%s", line[:10], len(line), line[len(line)-10:])
+		}
+		quoted = append(quoted, fmt.Sprintf("%q", line))
+	}
+	return "[" + strings.Join(quoted, " ") + "]"
+}
+
+func TestLineSplitterTimesLines(t *testing.T) {
+	var times []time.Time
+	s := newLineSplitter(nil, func(raw string, partial bool, t time.Time) {
+		times = append(times, t)
+	})
+	before := time.Now()
+	s.Write([]byte("one"))
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now()
+	s.Write([]byte(" two\nthree\n"))
+	s.Close()
+
+	if len(times) != 2 {
+		t.Fatalf("got %d lines, want 2", len(times))
+	}
+	if times[0].Before(before) || !times[0].Before(middle) {
+		t.Errorf("first line time %v, want the time its first byte was read", times[0])
+	}
+	if times[1].Before(middle) {
+		t.Errorf("second line time %v, want the time of the second read", times[1])
+	}
+}
+
+func TestLineSplitterFlushesPartialLines(t *testing.T) {
+	lines := make(chan string, 1)
+	s := newLineSplitter(nil, func(raw string, partial bool, t time.Time) {
+		lines <- fmt.Sprintf("%q partial=%v", raw, partial)
+	})
+	s.Write([]byte("Password: "))
+	select {
+	case got := <-lines:
+		if want := `"Password: " partial=true`; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	case <-time.After(10 * partialLineDelay):
+		t.Fatal("unterminated line was not emitted")
+	}
+	s.Close()
+}
+
+func TestCompleteRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"é", 2},
+		{"a\xc3", 1},
+		{"a\xe2\x9c", 1},
+		{"a\xe2\x9c\x93", 4},
+		{"\x80\x80", 2}, // Invalid input is passed through
+	}
+	for _, tt := range tests {
+		if got := completeRunes([]byte(tt.in)); got != tt.want {
+			t.Errorf("completeRunes(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLineText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain\n", "plain"},
+		{"\x1b[32mgreen\x1b[0m\n", "green"},
+		{"\r10%\r100%\n", "100%"},
+		{"no newline", "no newline"},
+	}
+	for _, tt := range tests {
+		if got := lineText(tt.in); got != tt.want {
+			t.Errorf("lineText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The logs of finished jobs are moved from job_logs into compressed blocks
+// of consecutive lines. Build output is repetitive, so a block takes a
+// fraction of the space of its lines.
+const (
+	blockMaxLines = 1000
+	blockMaxBytes = 256 * 1024
+)
+
+// Flags of an encoded log line.
+const (
+	blockLineStderr = 1 << iota
+	blockLinePartial
+	blockLineStructured // Followed by the level, message and time of a JSON log line
+)
+
+// encodeBlock compresses log lines into a block. Each line is encoded as
+// its sequence number, time in Unix nanoseconds, flags, and the length and
+// bytes of its raw text. JSON log lines continue with the length and bytes
+// of their level and message, and their logged time in Unix nanoseconds or
+// 0 if unknown.
+func encodeBlock(lines []LogMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	var scratch [3*binary.MaxVarintLen64 + 1]byte
+	for _, line := range lines {
+		var flags byte
+		if line.Stream == StreamStderr {
+			flags |= blockLineStderr
+		}
+		if line.Partial {
+			flags |= blockLinePartial
+		}
+		structured := line.Level != "" || line.Message != "" || !line.LoggedAt.IsZero()
+		if structured {
+			flags |= blockLineStructured
+		}
+		n := binary.PutUvarint(scratch[:], uint64(line.Seq))
+		n += binary.PutVarint(scratch[n:], line.Time.UnixNano())
+		scratch[n] = flags
+		n++
+		n += binary.PutUvarint(scratch[n:], uint64(len(line.RawText)))
+		if _, err := zw.Write(scratch[:n]); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(zw, line.RawText); err != nil {
+			return nil, err
+		}
+		if !structured {
+			continue
+		}
+
+		var loggedAt int64
+		if !line.LoggedAt.IsZero() {
+			loggedAt = line.LoggedAt.UnixNano()
+		}
+		for _, s := range []string{line.Level, line.Message} {
+			n := binary.PutUvarint(scratch[:], uint64(len(s)))
+			if _, err := zw.Write(scratch[:n]); err != nil {
+				return nil, err
+			}
+			if _, err := io.WriteString(zw, s); err != nil {
+				return nil, err
+			}
+		}
+		n = binary.PutVarint(scratch[:], loggedAt)
+		if _, err := zw.Write(scratch[:n]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBlock returns the log lines of a block.
+func decodeBlock(jobID string, data []byte) ([]LogMessage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress log block: %w", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress log block: %w", err)
+	}
+
+	r := bytes.NewReader(raw)
+	var lines []LogMessage
+	for r.Len() > 0 {
+		seq, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errCorruptBlock
+		}
+		nanos, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errCorruptBlock
+		}
+		flags, err := r.ReadByte()
+		if err != nil {
+			return nil, errCorruptBlock
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(r.Len()) {
+			return nil, errCorruptBlock
+		}
+		content := make([]byte, size)
+		r.Read(content)
+
+		var fields [2]string
+		var loggedAt int64
+		if flags&blockLineStructured != 0 {
+			for i := range fields {
+				size, err := binary.ReadUvarint(r)
+				if err != nil || size > uint64(r.Len()) {
+					return nil, errCorruptBlock
+				}
+				field := make([]byte, size)
+				r.Read(field)
+				fields[i] = string(field)
+			}
+			if loggedAt, err = binary.ReadVarint(r); err != nil {
+				return nil, errCorruptBlock
+			}
+		}
+
+		stream := StreamStdout
+		if flags&blockLineStderr != 0 {
+			stream = StreamStderr
+		}
+		line := LogMessage{
+			JobID:   jobID,
+			Seq:     int64(seq),
+			Stream:  stream,
+			Text:    lineText(string(content)),
+			RawText: string(content),
+			Partial: flags&blockLinePartial != 0,
+			Time:    time.Unix(0, nanos).UTC(),
+			Level:   fields[0],
+			Message: fields[1],
+		}
+		if loggedAt != 0 {
+			line.LoggedAt = time.Unix(0, loggedAt)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+var errCorruptBlock = errors.New("corrupt log block")
+
+// insertBlock stores log lines, in sequence order, as one block.
+func insertBlock(tx *sql.Tx, jobID string, lines []LogMessage) error {
+	data, err := encodeBlock(lines)
+	if err != nil {
+		return fmt.Errorf("failed to compress log block: %w", err)
+	}
+
+	minTime, maxTime := lines[0].Time, lines[0].Time
+	var size int
+	for _, line := range lines {
+		if line.Time.Before(minTime) {
+			minTime = line.Time
+		}
+		if line.Time.After(maxTime) {
+			maxTime = line.Time
+		}
+		size += len(line.RawText)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO job_log_blocks (job_id, first_seq, last_seq, min_time, max_time, lines, bytes, data)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		jobID,
+		lines[0].Seq,
+		lines[len(lines)-1].Seq,
+		minTime.UnixNano(),
+		maxTime.UnixNano(),
+		len(lines),
+		size,
+		data,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert log block: %w", err)
+	}
+	return nil
+}
+
+// insertBlocks stores log lines, in sequence order, split into blocks.
+func insertBlocks(tx *sql.Tx, jobID string, lines []LogMessage) error {
+	for len(lines) > 0 {
+		n, size := 0, 0
+		for n < len(lines) && n < blockMaxLines && (n == 0 || size+len(lines[n].RawText) <= blockMaxBytes) {
+			size += len(lines[n].RawText)
+			n++
+		}
+		if err := insertBlock(tx, jobID, lines[:n]); err != nil {
+			return err
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// CompactJobLogs moves the logs of a finished job into compressed blocks.
+func (s *SQLiteStorage) CompactJobLogs(jobID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lines are read a block at a time
+	q := LogQuery{JobID: jobID, Limit: blockMaxLines}
+	for {
+		lines, err := queryRowLogs(tx, q)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			break
+		}
+		if err := insertBlocks(tx, jobID, lines); err != nil {
+			return err
+		}
+		q.From = lines[len(lines)-1].Seq + 1
+	}
+
+	if _, err := tx.Exec(`DELETE FROM job_logs WHERE job_id = ?`, jobID); err != nil {
+		return fmt.Errorf("failed to remove compacted logs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// compactFinishedJobs compresses the logs of finished jobs that are still
+// stored as rows, like those written before logs were compressed.
+func (s *SQLiteStorage) compactFinishedJobs() error {
+	rows, err := s.db.Query(`
+        SELECT id FROM jobs
+        WHERE status != 'running' AND EXISTS (SELECT 1 FROM job_logs WHERE job_id = jobs.id)`)
+	if err != nil {
+		return fmt.Errorf("failed to query jobs to compact: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan job row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating job rows: %w", err)
+	}
+
+	for _, id := range ids {
+		if err := s.CompactJobLogs(id); err != nil {
+			return fmt.Errorf("failed to compact logs of job %s: %w", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		fmt.Printf("Compacted the logs of %d jobs\n", len(ids))
+	}
+	return nil
+}
+
+// queryBlockLogs returns the lines in a job's log blocks matching q, in
+// sequence order.
+func queryBlockLogs(tx *sql.Tx, q LogQuery) ([]LogMessage, error) {
+	conds := "job_id = ?"
+	args := []interface{}{q.JobID}
+	if q.From > 0 {
+		conds += " AND last_seq >= ?"
+		args = append(args, q.From)
+	}
+	if !q.Start.IsZero() {
+		conds += " AND max_time >= ?"
+		args = append(args, q.Start.UnixNano())
+	}
+	if !q.End.IsZero() {
+		conds += " AND min_time < ?"
+		args = append(args, q.End.UnixNano())
+	}
+
+	// For the tail blocks are read from the end, and lines collected in
+	// reverse
+	order, limit := "ASC", q.Limit
+	if q.Tail > 0 {
+		order, limit = "DESC", q.Tail
+	}
+	rows, err := tx.Query(`
+        SELECT data FROM job_log_blocks
+        WHERE `+conds+`
+        ORDER BY first_seq `+order,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []LogMessage
+	for (limit <= 0 || len(logs) < limit) && rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan log block row: %w", err)
+		}
+		lines, err := decodeBlock(q.JobID, data)
+		if err != nil {
+			return nil, err
+		}
+		for i := range lines {
+			line := lines[i]
+			if q.Tail > 0 {
+				line = lines[len(lines)-1-i]
+			}
+			if !q.matches(line) {
+				continue
+			}
+			logs = append(logs, line)
+			if limit > 0 && len(logs) == limit {
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating log block rows: %w", err)
+	}
+
+	if q.Tail > 0 {
+		for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+			logs[i], logs[j] = logs[j], logs[i]
+		}
+	}
+	return logs, nil
+}
+
+// blockMeta describes a stored log block.
+type blockMeta struct {
+	id       int64
+	firstSeq int64
+	lastSeq  int64
+	lines    int64
+	bytes    int64
+}
+
+// truncationMarkerBytes is the space reserved for the line that replaces
+// truncated logs.
+const truncationMarkerBytes = 128
+
+// TruncateJobLogs shrinks the logs of a finished job to at most maxBytes by
+// removing lines from the middle, keeping the head and tail of the log.
+// The removed lines are replaced by a single line saying what was removed.
+func (s *SQLiteStorage) TruncateJobLogs(jobID string, maxBytes int64) (lines int64, bytes int64, err error) {
+	// Truncation works on blocks
+	if err := s.CompactJobLogs(jobID); err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	blocks, err := jobBlocks(tx, jobID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, b := range blocks {
+		total += b.bytes
+	}
+	if total <= maxBytes {
+		return 0, 0, nil
+	}
+
+	// The removed range starts at the first line beyond half the limit
+	// counted from the head, and ends at the last one counted from the tail
+	half := (maxBytes - truncationMarkerBytes) / 2
+	if half < 0 {
+		half = 0
+	}
+	first, err := findCut(tx, jobID, blocks, half, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := findCut(tx, jobID, blocks, half, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if first.Seq > last.Seq {
+		return 0, 0, nil
+	}
+
+	// Blocks entirely within the range are dropped, those overlapping its
+	// ends are rewritten with the lines outside of it
+	var kept []LogMessage
+	for _, b := range blocks {
+		if b.lastSeq < first.Seq || b.firstSeq > last.Seq {
+			continue
+		}
+		if b.firstSeq < first.Seq || b.lastSeq > last.Seq {
+			blockLines, err := readBlock(tx, jobID, b.id)
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, line := range blockLines {
+				if line.Seq >= first.Seq && line.Seq <= last.Seq {
+					lines++
+					bytes += int64(len(line.RawText))
+				} else {
+					kept = append(kept, line)
+				}
+			}
+		} else {
+			lines += b.lines
+			bytes += b.bytes
+		}
+		if _, err := tx.Exec(`DELETE FROM job_log_blocks WHERE id = ?`, b.id); err != nil {
+			return 0, 0, fmt.Errorf("failed to remove log block: %w", err)
+		}
+	}
+
+	// The marker takes the place, and time, of the first removed line
+	marker := LogMessage{
+		JobID:   jobID,
+		Seq:     first.Seq,
+		Stream:  StreamStdout,
+		RawText: fmt.Sprintf("[%d lines (%d bytes) removed by the retention policy]\n", lines, bytes),
+		Time:    first.Time,
+	}
+	i := sort.Search(len(kept), func(i int) bool { return kept[i].Seq > marker.Seq })
+	rewritten := append(append(kept[:i:i], marker), kept[i:]...)
+	if err := insertBlocks(tx, jobID, rewritten); err != nil {
+		return 0, 0, err
+	}
+
+	// Removed lines can't be found anymore
+	if _, err := tx.Exec(`
+        DELETE FROM job_logs_fts
+        WHERE rowid BETWEEN (SELECT (log_key << 32) + ? FROM jobs WHERE id = ?)
+                        AND (SELECT (log_key << 32) + ? FROM jobs WHERE id = ?)`,
+		first.Seq, jobID, last.Seq, jobID,
+	); err != nil {
+		return 0, 0, fmt.Errorf("failed to remove logs from search index: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return lines, bytes, nil
+}
+
+// jobBlocks returns the blocks of a job in sequence order.
+func jobBlocks(tx *sql.Tx, jobID string) ([]blockMeta, error) {
+	rows, err := tx.Query(`
+        SELECT id, first_seq, last_seq, lines, bytes
+        FROM job_log_blocks
+        WHERE job_id = ?
+        ORDER BY first_seq`,
+		jobID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []blockMeta
+	for rows.Next() {
+		var b blockMeta
+		if err := rows.Scan(&b.id, &b.firstSeq, &b.lastSeq, &b.lines, &b.bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan log block row: %w", err)
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating log block rows: %w", err)
+	}
+	return blocks, nil
+}
+
+func readBlock(tx *sql.Tx, jobID string, id int64) ([]LogMessage, error) {
+	var data []byte
+	if err := tx.QueryRow(`SELECT data FROM job_log_blocks WHERE id = ?`, id).Scan(&data); err != nil {
+		return nil, fmt.Errorf("failed to read log block: %w", err)
+	}
+	return decodeBlock(jobID, data)
+}
+
+// findCut returns the first line, counted from the head or from the tail,
+// at which the log grows beyond limit bytes. Only the block containing it
+// is decompressed.
+func findCut(tx *sql.Tx, jobID string, blocks []blockMeta, limit int64, fromTail bool) (LogMessage, error) {
+	var size int64
+	for i := range blocks {
+		b := blocks[i]
+		if fromTail {
+			b = blocks[len(blocks)-1-i]
+		}
+		if size+b.bytes <= limit {
+			size += b.bytes
+			continue
+		}
+
+		lines, err := readBlock(tx, jobID, b.id)
+		if err != nil {
+			return LogMessage{}, err
+		}
+		for j := range lines {
+			line := lines[j]
+			if fromTail {
+				line = lines[len(lines)-1-j]
+			}
+			size += int64(len(line.RawText))
+			if size > limit {
+				return line, nil
+			}
+		}
+	}
+	return LogMessage{}, errCorruptBlock
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockRoundTrip(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 123, time.UTC)
+	lines := []LogMessage{
+		{JobID: "job", Seq: 1, Stream: StreamStdout, RawText: "\x1b[32mok\x1b[0m\n", Text: "ok", Time: start},
+		{JobID: "job", Seq: 2, Stream: StreamStderr, RawText: "Password: ", Text: "Password: ", Partial: true, Time: start.Add(time.Second)},
+		{JobID: "job", Seq: 5, Stream: StreamStdout, RawText: `{"level":"warn","msg":"slow"}` + "\n", Text: `{"level":"warn","msg":"slow"}`,
+			Time: start.Add(2 * time.Second), Level: "warn", Message: "slow", LoggedAt: start.Add(-time.Hour)},
+		{JobID: "job", Seq: 6, Stream: StreamStdout, RawText: `{"level":"info"}` + "\n", Text: `{"level":"info"}`, Time: start, Level: "info"},
+		{JobID: "job", Seq: 7, Stream: StreamStdout, RawText: "", Text: "", Time: start},
+	}
+
+	data, err := encodeBlock(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeBlock("job", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(lines) {
+		t.Fatalf("got %d lines, want %d", len(got), len(lines))
+	}
+	for i := range lines {
+		want := lines[i]
+		if !got[i].Time.Equal(want.Time) || !got[i].LoggedAt.Equal(want.LoggedAt) {
+			t.Errorf("line %d has times %v and %v, want %v and %v", i, got[i].Time, got[i].LoggedAt, want.Time, want.LoggedAt)
+		}
+		got[i].Time, got[i].LoggedAt = want.Time, want.LoggedAt
+		if !reflect.DeepEqual(got[i], want) {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
+
+func TestDecodeCorruptBlock(t *testing.T) {
+	if _, err := decodeBlock("job", []byte("not gzip")); err == nil {
+		t.Error("decoded data that isn't compressed")
+	}
+
+	// A line claiming more bytes than the block holds
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte{1, 0, 0, 100, 'a'})
+	zw.Close()
+	if _, err := decodeBlock("job", buf.Bytes()); err != errCorruptBlock {
+		t.Errorf("got error %v, want %v", err, errCorruptBlock)
+	}
+}
+
+func TestCompactJobLogs(t *testing.T) {
+	s := newTestStorage(t)
+	var lines []string
+	for i := range 2*blockMaxLines + 10 {
+		lines = append(lines, fmt.Sprintf("line %d\n", i+1))
+	}
+	createTestJob(t, s, "job", lines...)
+
+	queries := []LogQuery{
+		{JobID: "job"},
+		{JobID: "job", From: 995, Limit: 10},
+		{JobID: "job", Tail: 15},
+		{JobID: "job", From: 1500, Tail: 3},
+		{JobID: "job", Start: time.Date(2025, 6, 1, 12, 16, 0, 0, time.UTC), End: time.Date(2025, 6, 1, 12, 17, 0, 0, time.UTC)},
+	}
+	var before [][]LogMessage
+	for _, q := range queries {
+		logs, err := s.QueryJobLogs(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before = append(before, logs)
+	}
+
+	if err := s.CompactJobLogs("job"); err != nil {
+		t.Fatal(err)
+	}
+	var rows, blocks int
+	s.db.QueryRow(`SELECT COUNT(*) FROM job_logs`).Scan(&rows)
+	s.db.QueryRow(`SELECT COUNT(*) FROM job_log_blocks`).Scan(&blocks)
+	if rows != 0 || blocks != 3 {
+		t.Errorf("got %d rows and %d blocks after compaction, want 0 and 3", rows, blocks)
+	}
+
+	for i, q := range queries {
+		logs, err := s.QueryJobLogs(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != len(before[i]) {
+			t.Errorf("query %+v returned %d lines after compaction, %d before", q, len(logs), len(before[i]))
+			continue
+		}
+		for j := range logs {
+			if logs[j].Seq != before[i][j].Seq || logs[j].RawText != before[i][j].RawText || !logs[j].Time.Equal(before[i][j].Time) {
+				t.Errorf("query %+v line %d = %+v after compaction, %+v before", q, j, logs[j], before[i][j])
+				break
+			}
+		}
+	}
+}
+
+func TestInsertBlocksSplitsLargeOutput(t *testing.T) {
+	s := newTestStorage(t)
+	line := strings.Repeat("x", blockMaxBytes/3) + "\n"
+	createTestJob(t, s, "job", line, line, line, line)
+	if err := s.CompactJobLogs("job"); err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks int
+	s.db.QueryRow(`SELECT COUNT(*) FROM job_log_blocks`).Scan(&blocks)
+	if blocks != 2 {
+		t.Errorf("got %d blocks, want 2", blocks)
+	}
+}
+
+func TestTruncateJobLogs(t *testing.T) {
+	s := newTestStorage(t)
+	var lines []string
+	for i := range 100 {
+		lines = append(lines, fmt.Sprintf("line %03d of the output\n", i+1)) // 23 bytes
+	}
+	createTestJob(t, s, "job", lines...)
+
+	removed, size, err := s.TruncateJobLogs("job", truncationMarkerBytes+2*10*23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 80 || size != 80*23 {
+		t.Errorf("removed %d lines of %d bytes, want 80 lines of %d bytes", removed, size, 80*23)
+	}
+
+	logs, err := s.QueryJobLogs(LogQuery{JobID: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 21 {
+		t.Fatalf("got %d lines, want 21", len(logs))
+	}
+	if logs[9].Seq != 10 || logs[11].Seq != 91 {
+		t.Errorf("kept lines %d and %d around the marker, want 10 and 91", logs[9].Seq, logs[11].Seq)
+	}
+	if want := "[80 lines (1840 bytes) removed by the retention policy]"; logs[10].Seq != 11 || logs[10].Text != want {
+		t.Errorf("got marker %d %q, want 11 %q", logs[10].Seq, logs[10].Text, want)
+	}
+
+	// Already small enough
+	if removed, _, err := s.TruncateJobLogs("job", 1<<20); err != nil || removed != 0 {
+		t.Errorf("truncating again removed %d lines, error %v", removed, err)
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import "unsafe"
+
+// DefaultLogBufferSize is how many bytes of recent output are kept in
+// memory for each running job.
+const DefaultLogBufferSize = 1 << 20
+
+// MaxLogBufferSize limits the buffer size a job may ask for.
+const MaxLogBufferSize = 256 << 20
+
+// logEntryOverhead is the memory a buffered message takes besides its text.
+const logEntryOverhead = int64(unsafe.Sizeof(LogMessage{}))
+
+// logRing holds the most recent output of a running job, up to a number of
+// bytes. It always holds a contiguous range of sequence numbers ending with
+// the last message, so clients catching up with a job's recent output
+// don't need to read storage.
+type logRing struct {
+	msgs    []LogMessage // Buffered messages, oldest first, from head on
+	head    int
+	size    int64 // Bytes taken by the buffered messages
+	maxSize int64
+	next    int64 // Sequence number of the next message
+}
+
+func newLogRing(maxSize int64) *logRing {
+	if maxSize <= 0 {
+		return nil
+	}
+	return &logRing{maxSize: maxSize, next: 1}
+}
+
+func logEntrySize(msg LogMessage) int64 {
+	return int64(len(msg.RawText)+len(msg.Text)+len(msg.Message)) + logEntryOverhead
+}
+
+// push adds a message, evicting the oldest messages to stay within the
+// size limit. A message larger than the whole buffer leaves it empty.
+func (r *logRing) push(msg LogMessage) {
+	if r == nil {
+		return
+	}
+	size := logEntrySize(msg)
+	for r.head < len(r.msgs) && r.size+size > r.maxSize {
+		r.size -= logEntrySize(r.msgs[r.head])
+		r.msgs[r.head] = LogMessage{}
+		r.head++
+	}
+	// Reclaim the space of evicted messages once they make up half the
+	// slice, which keeps pushing amortized constant time
+	if r.head > 0 && r.head >= len(r.msgs)/2 {
+		n := copy(r.msgs, r.msgs[r.head:])
+		clear(r.msgs[n:])
+		r.msgs = r.msgs[:n]
+		r.head = 0
+	}
+
+	r.next = msg.Seq + 1
+	if size <= r.maxSize {
+		r.msgs = append(r.msgs, msg)
+		r.size += size
+	}
+}
+
+// since returns the buffered messages with a sequence number greater than
+// seq. ok is false if some of them were already evicted.
+func (r *logRing) since(seq int64) (logs []LogMessage, ok bool) {
+	if r == nil {
+		return nil, false
+	}
+	first := r.next
+	if r.head < len(r.msgs) {
+		first = r.msgs[r.head].Seq
+	}
+	if seq+1 < first {
+		return nil, false
+	}
+
+	buffered := r.msgs[r.head:]
+	i := max(int(seq+1-first), 0)
+	if i >= len(buffered) {
+		return nil, true
+	}
+	return append([]LogMessage(nil), buffered[i:]...), true
+}
+
+// oldest returns the sequence number of the oldest buffered message, or
+// zero if the buffer is empty.
+func (r *logRing) oldest() int64 {
+	if r == nil || r.head == len(r.msgs) {
+		return 0
+	}
+	return r.msgs[r.head].Seq
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// ringMessage returns a message whose entry in a logRing takes size bytes.
+func ringMessage(seq int64, size int) LogMessage {
+	return LogMessage{Seq: seq, RawText: strings.Repeat("x", size-int(logEntryOverhead))}
+}
+
+func seqs(logs []LogMessage) []int64 {
+	var seqs []int64
+	for _, log := range logs {
+		seqs = append(seqs, log.Seq)
+	}
+	return seqs
+}
+
+func TestLogRing(t *testing.T) {
+	size := int(logEntryOverhead) + 10
+	r := newLogRing(int64(3 * size))
+
+	if logs, ok := r.since(0); !ok || len(logs) != 0 {
+		t.Errorf("empty buffer: got %v, %v, want nothing and ok", seqs(logs), ok)
+	}
+	if r.oldest() != 0 {
+		t.Errorf("empty buffer: oldest %d, want 0", r.oldest())
+	}
+
+	for seq := int64(1); seq <= 5; seq++ {
+		r.push(ringMessage(seq, size))
+	}
+	tests := []struct {
+		since int64
+		want  []int64
+		ok    bool
+	}{
+		{0, nil, false},
+		{1, nil, false},
+		{2, []int64{3, 4, 5}, true},
+		{4, []int64{5}, true},
+		{5, nil, true},
+		{9, nil, true},
+	}
+	for _, tt := range tests {
+		logs, ok := r.since(tt.since)
+		if ok != tt.ok || len(logs) != len(tt.want) || (len(logs) > 0 && logs[0].Seq != tt.want[0]) {
+			t.Errorf("since(%d) = %v, %v, want %v, %v", tt.since, seqs(logs), ok, tt.want, tt.ok)
+		}
+	}
+	if r.oldest() != 3 {
+		t.Errorf("oldest %d, want 3", r.oldest())
+	}
+	if r.size != int64(3*size) {
+		t.Errorf("size %d, want %d", r.size, 3*size)
+	}
+}
+
+func TestLogRingEvictsBySize(t *testing.T) {
+	small := int(logEntryOverhead) + 10
+	r := newLogRing(int64(4 * small))
+	for seq := int64(1); seq <= 4; seq++ {
+		r.push(ringMessage(seq, small))
+	}
+
+	// A large message evicts as many small ones as it needs
+	r.push(ringMessage(5, 2*small))
+	if logs, _ := r.since(0); len(logs) != 0 {
+		t.Errorf("got %v after evictions, want the start to be missing", seqs(logs))
+	}
+	if r.oldest() != 3 {
+		t.Errorf("oldest %d, want 3", r.oldest())
+	}
+
+	// A message larger than the buffer empties it, but sequence numbers
+	// continue after it
+	r.push(ringMessage(6, 5*small))
+	if r.oldest() != 0 {
+		t.Errorf("oldest %d after a huge message, want 0", r.oldest())
+	}
+	if logs, ok := r.since(6); !ok || len(logs) != 0 {
+		t.Errorf("since(6) = %v, %v, want nothing and ok", seqs(logs), ok)
+	}
+	if _, ok := r.since(5); ok {
+		t.Error("since(5) is ok, but the huge message isn't buffered")
+	}
+	r.push(ringMessage(7, small))
+	if logs, ok := r.since(6); !ok || len(logs) != 1 || logs[0].Seq != 7 {
+		t.Errorf("since(6) = %v, %v, want 7", seqs(logs), ok)
+	}
+}
+
+func TestLogRingReclaimsSpace(t *testing.T) {
+	size := int(logEntryOverhead) + 1
+	r := newLogRing(int64(10 * size))
+	for seq := int64(1); seq <= 10000; seq++ {
+		r.push(ringMessage(seq, size))
+	}
+	if len(r.msgs) > 20 {
+		t.Errorf("%d messages held for a buffer of 10", len(r.msgs))
+	}
+	logs, ok := r.since(9990)
+	if !ok || len(logs) != 10 || logs[9].Seq != 10000 {
+		t.Errorf("since(9990) = %v, %v, want 9991 to 10000", seqs(logs), ok)
+	}
+}
+
+func TestNilLogRing(t *testing.T) {
+	r := newLogRing(0)
+	if r != nil {
+		t.Fatal("got a buffer of size 0")
+	}
+	r.push(ringMessage(1, int(logEntryOverhead)))
+	if _, ok := r.since(0); ok {
+		t.Error("nil buffer claims to hold messages")
+	}
+	if r.oldest() != 0 {
+		t.Errorf("nil buffer: oldest %d, want 0", r.oldest())
+	}
+}
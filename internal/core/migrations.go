@@ -1,9 +1,16 @@
 package core
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
 
+// migrations are applied in order. The index of the last applied migration
+// is tracked in SQLite's user_version pragma, so new schema changes must
+// always be appended to the end of the list.
 var migrations = []string{
-    `CREATE TABLE IF NOT EXISTS jobs (
+	`CREATE TABLE IF NOT EXISTS jobs (
         id TEXT PRIMARY KEY,
         command TEXT NOT NULL,
         pid INTEGER,
@@ -12,7 +19,7 @@ var migrations = []string{
         stopped_at DATETIME,
         exit_code INTEGER
     )`,
-    `CREATE TABLE IF NOT EXISTS job_logs (
+	`CREATE TABLE IF NOT EXISTS job_logs (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         job_id TEXT NOT NULL,
         content TEXT NOT NULL,
@@ -20,13 +27,140 @@ var migrations = []string{
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
     )`,
+	`ALTER TABLE jobs ADD COLUMN signal TEXT`,
+	`ALTER TABLE jobs ADD COLUMN env TEXT`,
+	`ALTER TABLE jobs ADD COLUMN clean_env INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE jobs ADD COLUMN cwd TEXT`,
+	`ALTER TABLE jobs ADD COLUMN timeout_seconds INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE jobs ADD COLUMN tty INTEGER NOT NULL DEFAULT 0`,
+	// SQLite can't alter CHECK constraints, so the jobs table is rebuilt to
+	// allow the "lost" status and gain the reason column
+	`CREATE TABLE jobs_new (
+        id TEXT PRIMARY KEY,
+        command TEXT NOT NULL,
+        pid INTEGER,
+        status TEXT CHECK(status IN ('running', 'stopped', 'completed', 'failed', 'timeout', 'lost')) NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        stopped_at DATETIME,
+        exit_code INTEGER,
+        signal TEXT,
+        env TEXT,
+        clean_env INTEGER NOT NULL DEFAULT 0,
+        cwd TEXT,
+        timeout_seconds INTEGER NOT NULL DEFAULT 0,
+        tty INTEGER NOT NULL DEFAULT 0,
+        reason TEXT
+    );
+    INSERT INTO jobs_new (id, command, pid, status, created_at, stopped_at, exit_code, signal, env, clean_env, cwd, timeout_seconds, tty)
+        SELECT id, command, pid, status, created_at, stopped_at, exit_code, signal, env, clean_env, cwd, timeout_seconds, tty FROM jobs;
+    DROP TABLE jobs;
+    ALTER TABLE jobs_new RENAME TO jobs`,
+	// Per-job sequence numbers, numbering existing logs in the order they
+	// were read back before
+	`ALTER TABLE job_logs ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+    UPDATE job_logs SET seq = numbered.n
+        FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY job_id ORDER BY created_at, id) AS n FROM job_logs) AS numbered
+        WHERE job_logs.id = numbered.id;
+    CREATE INDEX idx_job_logs_job_seq ON job_logs(job_id, seq)`,
+	`ALTER TABLE job_logs ADD COLUMN partial INTEGER NOT NULL DEFAULT 0`,
+	// Full-text index of the plain text of each log line, keyed by the log's
+	// id. Existing logs can't have their ANSI codes stripped in SQL and are
+	// indexed as they are.
+	`CREATE VIRTUAL TABLE job_logs_fts USING fts5(text, job_id UNINDEXED, seq UNINDEXED);
+    INSERT INTO job_logs_fts (rowid, text, job_id, seq)
+        SELECT id, content, job_id, seq FROM job_logs;
+    CREATE TRIGGER job_logs_fts_delete AFTER DELETE ON job_logs BEGIN
+        DELETE FROM job_logs_fts WHERE rowid = old.id;
+    END`,
+	`ALTER TABLE jobs ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`,
+	// Compressed blocks of log lines, which the logs of finished jobs are
+	// moved to. Times are Unix nanoseconds.
+	`CREATE TABLE job_log_blocks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        job_id TEXT NOT NULL,
+        first_seq INTEGER NOT NULL,
+        last_seq INTEGER NOT NULL,
+        min_time INTEGER NOT NULL,
+        max_time INTEGER NOT NULL,
+        lines INTEGER NOT NULL,
+        bytes INTEGER NOT NULL,
+        data BLOB NOT NULL,
+        FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+    );
+    CREATE INDEX idx_job_log_blocks_job_seq ON job_log_blocks(job_id, first_seq)`,
+	// The search index can't be keyed by job_logs rows, which are removed
+	// once they're compressed. Each job gets a key instead, and lines are
+	// indexed under the key shifted left by 32 bits plus their sequence
+	// number, so a job's lines form a range of rowids. Times of existing
+	// lines are converted with second precision.
+	`ALTER TABLE jobs ADD COLUMN log_key INTEGER;
+    UPDATE jobs SET log_key = numbered.n
+        FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM jobs) AS numbered
+        WHERE jobs.id = numbered.id;
+    CREATE UNIQUE INDEX idx_jobs_log_key ON jobs(log_key);
+    DROP TRIGGER job_logs_fts_delete;
+    CREATE VIRTUAL TABLE job_logs_search USING fts5(text, job_id UNINDEXED, seq UNINDEXED, stream UNINDEXED, time UNINDEXED);
+    INSERT INTO job_logs_search (rowid, text, job_id, seq, stream, time)
+        SELECT (jobs.log_key << 32) + job_logs.seq, job_logs_fts.text, job_logs.job_id, job_logs.seq, job_logs.log_level,
+               CAST(strftime('%s', substr(job_logs.created_at, 1, 19)) AS INTEGER) * 1000000000
+        FROM job_logs_fts
+        JOIN job_logs ON job_logs.id = job_logs_fts.rowid
+        JOIN jobs ON jobs.id = job_logs.job_id;
+    DROP TABLE job_logs_fts;
+    ALTER TABLE job_logs_search RENAME TO job_logs_fts;
+    CREATE TRIGGER jobs_fts_delete AFTER DELETE ON jobs BEGIN
+        DELETE FROM job_logs_fts WHERE rowid BETWEEN old.log_key << 32 AND (old.log_key << 32) + 4294967295;
+    END`,
+	`ALTER TABLE jobs ADD COLUMN secrets TEXT`,
+	`ALTER TABLE jobs ADD COLUMN metadata TEXT`,
+	// Fields of JSON log lines. log_level holds the stream, level the
+	// level recorded in the line. logged_at is in Unix nanoseconds.
+	`ALTER TABLE jobs ADD COLUMN json_logs INTEGER NOT NULL DEFAULT 0;
+    ALTER TABLE job_logs ADD COLUMN level TEXT;
+    ALTER TABLE job_logs ADD COLUMN message TEXT;
+    ALTER TABLE job_logs ADD COLUMN logged_at INTEGER`,
+	`ALTER TABLE jobs ADD COLUMN log_buffer_size INTEGER NOT NULL DEFAULT 0`,
 }
 
 func migrate(db *sql.DB) error {
-    for _, query := range migrations {
-        if _, err := db.Exec(query); err != nil {
-            return err
-        }
-    }
-    return nil
+	ctx := context.Background()
+
+	// Migrations run on a single connection, because rebuilding a table
+	// requires foreign keys to be off, or dropping the old table would
+	// cascade to job_logs. The pragma can't be changed inside a transaction.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("failed to disable foreign keys: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		// PRAGMA statements don't support placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to set schema version %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+	return nil
 }
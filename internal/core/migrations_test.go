@@ -0,0 +1,88 @@
+package core
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	s := newTestStorage(t)
+
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version %d, want %d", version, len(migrations))
+	}
+
+	// Migrating an up to date database does nothing
+	if err := migrate(s.db); err != nil {
+		t.Fatalf("migrating again failed: %v", err)
+	}
+}
+
+// TestMigrateOriginalSchema opens a database created before schema versions
+// were tracked, which has no user_version and only the original tables.
+func TestMigrateOriginalSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "srun.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range migrations[:2] {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`
+        INSERT INTO jobs (id, command, pid, status, created_at, stopped_at, exit_code)
+            VALUES ('old', 'make', 42, 'completed', '2024-01-02 03:04:05', '2024-01-02 03:05:00', 0);
+        INSERT INTO job_logs (job_id, content, log_level, created_at) VALUES
+            ('old', 'building', 'stdout', '2024-01-02 03:04:06'),
+            ('old', 'warning: unused', 'stderr', '2024-01-02 03:04:07'),
+            ('old', 'done', 'stdout', '2024-01-02 03:04:07')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	job, err := s.GetJob("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Command != "make" || job.Status != "completed" || job.ExitCode == nil || *job.ExitCode != 0 {
+		t.Errorf("got job %+v, want the completed make job", job)
+	}
+
+	logs, err := s.QueryJobLogs(LogQuery{JobID: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"building", "warning: unused", "done"}
+	if len(logs) != len(want) {
+		t.Fatalf("got %d log lines, want %d", len(logs), len(want))
+	}
+	for i, log := range logs {
+		if log.Seq != int64(i+1) || log.RawText != want[i] {
+			t.Errorf("line %d = %d %q, want %d %q", i, log.Seq, log.RawText, i+1, want[i])
+		}
+	}
+	if logs[1].Stream != StreamStderr {
+		t.Errorf("second line is from %s, want stderr", logs[1].Stream)
+	}
+
+	matches, err := s.SearchLogs(SearchQuery{Query: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].JobID != "old" || matches[0].Seq != 2 {
+		t.Errorf("search found %+v, want line 2 of the old job", matches)
+	}
+}
@@ -1,61 +1,142 @@
 package core
 
 import (
-	"container/ring"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
-	"srun/internal/ansi"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Defaults for stopping jobs when the process manager doesn't override them.
+const (
+	DefaultStopSignal      = syscall.SIGTERM
+	DefaultStopGracePeriod = 10 * time.Second
+)
+
 type ProcessManager struct {
-	Mu        sync.RWMutex
-	Jobs      map[string]*Job
-	Store     Storage
-	LogChan   chan LogMessage
-	logBuffer []LogMessage
-	logMu     sync.Mutex
+	Mu             sync.RWMutex
+	Jobs           map[string]*Job
+	Store          Storage
+	Logs           *LogBroker    // Live log messages of running jobs
+	DefaultTimeout time.Duration // Applied to jobs without their own timeout, zero for none
+	// StopSignal and StopGracePeriod are used when a job is stopped without
+	// explicit options, or cancelled because of a timeout
+	StopSignal      syscall.Signal
+	StopGracePeriod time.Duration
+	Retention       RetentionPolicy  // Applied to finished jobs by the janitor
+	RedactPatterns  []*regexp.Regexp // Secrets redacted from the output of all jobs
+	LogBufferSize   int64            // Bytes of recent output kept in memory for jobs without their own size, zero for none
+	logBuffer       []LogMessage
+	dirtyJobs       []*Job        // Jobs whose metadata changed since the last flush
+	logMu           sync.Mutex    // Guards logBuffer and the jobs' sequence numbers and buffered output
+	flushMu         sync.Mutex    // Held while logBuffer is written to storage
+	stopLogs        chan struct{} // Closed to stop the background log writer
+	sinks           []*bufferedSink
+	retentionMu     sync.Mutex
+	lastRetention   *RetentionReport
 }
 
-func (pm *ProcessManager) StartJob(command string) (*Job, error) {
+func (pm *ProcessManager) StartJob(spec JobSpec) (*Job, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Create job with unique ID
 	job := &Job{
-		ID:        uuid.New().String(),
-		Command:   command,
-		Status:    "running",
-		StartedAt: time.Now(),
-		LogBuffer: ring.New(1000),
+		ID:         uuid.New().String(),
+		JobSpec:    spec,
+		Status:     "running",
+		StartedAt:  time.Now(),
+		logs:       newLogRing(pm.effectiveLogBufferSize(spec)),
+		done:       make(chan struct{}),
+		outputDone: make(chan struct{}),
 	}
 
-	// Create context without timeout - just use background context
-	ctx, cancel := context.WithCancel(context.Background())
+	// Enforce the job's timeout, falling back to the server-wide default
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := pm.effectiveTimeout(spec); timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 	job.Cancel = cancel
 
 	// Prepare command
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", spec.Command)
+	cmd.Dir = spec.Cwd
+	cmd.Env = spec.environ()
+	// Run the job in its own process group so stopping it also reaches
+	// any children started by the shell
+	setProcessGroup(cmd)
+	// Cancellation and timeouts stop the job gracefully
+	cmd.Cancel = func() error {
+		return pm.terminate(job, pm.stopSignal(), pm.stopGracePeriod())
+	}
 	job.Cmd = cmd
 
-	// Set up pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	// Connect the job's stdio either to a pseudo-terminal or to pipes
+	var outputs []outputSource
+	if spec.TTY {
+		master, slave, err := openPTY()
+		if err != nil {
+			return nil, err
+		}
+		// The server only needs the slave side until the job has started
+		defer slave.Close()
+		if err := setPTYSize(master, defaultPTYCols, defaultPTYRows); err != nil {
+			master.Close()
+			return nil, fmt.Errorf("failed to set terminal size: %w", err)
+		}
+
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		// The terminal becomes the controlling terminal of a new session,
+		// which also makes the job the leader of its own process group
+		setControllingTerminal(cmd)
+		job.pty = master
+		job.stdin = master
+		outputs = append(outputs, outputSource{ptyReader{master}, StreamStdout})
+	} else {
+		// Set up pipes for stdin, stdout and stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		job.stdin = stdin
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		}
+		outputs = append(outputs,
+			outputSource{stdout, StreamStdout},
+			outputSource{stderr, StreamStderr},
+		)
 	}
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
+		if job.pty != nil {
+			job.pty.Close()
+		}
+
 		// Update job status to failed since command couldn't even start
 		job.Status = "failed"
 		job.CompletedAt = time.Now()
+		close(job.done)
 
 		// Store the failed job
 		pm.Mu.Lock()
@@ -100,56 +181,119 @@ func (pm *ProcessManager) StartJob(command string) (*Job, error) {
 	}()
 
 	// Handle command output in goroutines
-	go pm.handleOutput(stdout, job.ID)
-	go pm.handleOutput(stderr, job.ID)
+	var output sync.WaitGroup
+	for _, src := range outputs {
+		output.Add(1)
+		go func(src outputSource) {
+			defer output.Done()
+			pm.handleOutput(src.r, job, src.stream)
+		}(src)
+	}
 
 	// Monitor command completion
 	go func() {
+		// Wait closes the pipes, so all output has to be read first. The
+		// pipes reach EOF once every process in the group has closed them.
+		output.Wait()
+		close(job.outputDone)
 		err := cmd.Wait()
+		job.Cancel()
+		if job.pty != nil {
+			job.stdinMu.Lock()
+			job.stdin = nil
+			job.pty.Close()
+			job.stdinMu.Unlock()
+		}
+
 		pm.Mu.Lock()
 		job.CompletedAt = time.Now()
-		if err != nil {
-			if ctx.Err() == context.DeadlineExceeded {
-				job.Status = "timeout"
-			} else if ctx.Err() == context.Canceled {
-				// Job was intentionally stopped, keep the "stopped" status
-				if job.Status != "stopped" {
-					job.Status = "failed"
-				}
-			} else {
-				// Check if the error contains an exit code
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-						fmt.Printf("Command failed with exit code: %d\n", status.ExitStatus())
-						job.Status = "failed"
-					}
-				} else {
-					job.Status = "failed"
-				}
-			}
-		} else {
+		recordExit(job, cmd.ProcessState)
+		switch {
+		case job.Status == "stopped":
+			// Job was intentionally stopped, keep the "stopped" status
+		case ctx.Err() == context.DeadlineExceeded:
+			job.Status = "timeout"
+		case err != nil:
+			job.Status = "failed"
+		default:
 			job.Status = "completed"
 		}
 		pm.Mu.Unlock()
 
 		// Flush any remaining logs before updating status
 		pm.flushLogs()
+		for _, sink := range pm.sinks {
+			sink.enqueue(sinkEntry{finished: job.ID})
+		}
 
-		// Update existing job record with final status
-		if err := pm.Store.UpdateJobStatus(job.ID, job.Status); err != nil {
+		// Update existing job record with final status and exit information
+		if err := pm.Store.FinishJob(job); err != nil {
 			fmt.Printf("Failed to update job status: %v\n", err)
 		}
+		if err := pm.Store.CompactJobLogs(job.ID); err != nil {
+			fmt.Printf("Failed to compact logs: %v\n", err)
+		}
+
+		// All output has been published, end the live log streams.
+		// Clients catch up from storage from now on.
+		pm.Mu.Lock()
+		pm.Logs.CloseJob(job.ID)
+		close(job.done)
+		pm.logMu.Lock()
+		job.logs = nil
+		pm.logMu.Unlock()
+		pm.Mu.Unlock()
 	}()
 
 	return job, nil
 }
 
+// recordExit stores the exit code or terminating signal of a finished
+// process on the job.
+func recordExit(job *Job, state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return
+	}
+	if status.Signaled() {
+		job.Signal = signalName(status.Signal())
+		return
+	}
+	code := status.ExitStatus()
+	job.ExitCode = &code
+}
+
+// effectiveTimeout returns the timeout that applies to a job started from
+// spec, or zero if the job may run forever.
+func (pm *ProcessManager) effectiveTimeout(spec JobSpec) time.Duration {
+	if spec.Timeout > 0 {
+		return spec.Timeout
+	}
+	return pm.DefaultTimeout
+}
+
+// effectiveLogBufferSize returns how many bytes of recent output are kept
+// in memory for a job started from spec.
+func (pm *ProcessManager) effectiveLogBufferSize(spec JobSpec) int64 {
+	if spec.LogBufferSize > 0 {
+		return spec.LogBufferSize
+	}
+	return pm.LogBufferSize
+}
+
 func NewProcessManager(store Storage) *ProcessManager {
 	pm := &ProcessManager{
 		Jobs:      make(map[string]*Job),
 		Store:     store,
-		LogChan:   make(chan LogMessage, 1000),
+		Logs:      NewLogBroker(DefaultSubscriberBuffer),
 		logBuffer: make([]LogMessage, 0, 1000),
+		stopLogs:  make(chan struct{}),
+
+		RedactPatterns: DefaultRedactPatterns,
+		LogBufferSize:  DefaultLogBufferSize,
 	}
 	pm.startLogWriter()
 	return pm
@@ -158,13 +302,22 @@ func NewProcessManager(store Storage) *ProcessManager {
 func (pm *ProcessManager) startLogWriter() {
 	ticker := time.NewTicker(50 * time.Millisecond) // Reduce to 50ms for more responsive updates
 	go func() {
-		for range ticker.C {
-			pm.flushLogs()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pm.flushLogs()
+			case <-pm.stopLogs:
+				return
+			}
 		}
 	}()
 }
 
 func (pm *ProcessManager) flushLogs() {
+	pm.flushMu.Lock()
+	defer pm.flushMu.Unlock()
+
 	pm.logMu.Lock()
 	if len(pm.logBuffer) == 0 {
 		pm.logMu.Unlock()
@@ -175,6 +328,11 @@ func (pm *ProcessManager) flushLogs() {
 	logsToWrite := make([]LogMessage, len(pm.logBuffer))
 	copy(logsToWrite, pm.logBuffer)
 	pm.logBuffer = pm.logBuffer[:0]
+	dirtyJobs := pm.dirtyJobs
+	pm.dirtyJobs = nil
+	for _, job := range dirtyJobs {
+		job.dirty = false
+	}
 	pm.logMu.Unlock()
 
 	// Write to storage in smaller batches for more frequent updates
@@ -190,6 +348,147 @@ func (pm *ProcessManager) flushLogs() {
 			fmt.Printf("Error writing logs: %v\n", err)
 		}
 	}
+
+	for _, job := range dirtyJobs {
+		if err := pm.Store.UpdateJobMetadata(job.ID, job.Metadata()); err != nil {
+			fmt.Printf("Error writing job metadata: %v\n", err)
+		}
+	}
+}
+
+// QueryLogs returns the logs of a job matching q, including output that
+// hasn't been written to storage yet.
+func (pm *ProcessManager) QueryLogs(q LogQuery) ([]LogMessage, error) {
+	pm.flushLogs()
+	return pm.Store.QueryJobLogs(q)
+}
+
+// SearchLogs searches the logs of all jobs, including output that hasn't
+// been written to storage yet.
+func (pm *ProcessManager) SearchLogs(q SearchQuery) ([]SearchMatch, error) {
+	pm.flushLogs()
+	return pm.Store.SearchLogs(q)
+}
+
+// logPageSize is the number of logs StreamLogs reads from storage at once.
+const logPageSize = 1000
+
+// StreamLogs calls fn for each log of a job matching q, in sequence order,
+// stopping at the first error. Logs are read from storage page by page, so
+// neither the whole log is held in memory nor the database is kept busy
+// while fn is slow. The query's Limit and Tail are ignored.
+func (pm *ProcessManager) StreamLogs(q LogQuery, fn func(LogMessage) error) error {
+	pm.flushLogs()
+
+	q.Limit, q.Tail = logPageSize, 0
+	for {
+		logs, err := pm.Store.QueryJobLogs(q)
+		if err != nil {
+			return err
+		}
+		for _, log := range logs {
+			if err := fn(log); err != nil {
+				return err
+			}
+		}
+		if len(logs) < logPageSize {
+			return nil
+		}
+		q.From = logs[len(logs)-1].Seq + 1
+	}
+}
+
+// SubscribeLogs returns the logs of a job with a sequence number greater
+// than since, followed by a subscription to its live output. Together they
+// form one ordered sequence without gaps or duplicates. The subscription is
+// nil if the job isn't running in this process manager, in which case all
+// of its output is already in the returned logs.
+//
+// The recent output of running jobs is served from memory, storage is only
+// read for older history. The logs end with previews of the lines the job
+// is still printing, if any.
+func (pm *ProcessManager) SubscribeLogs(id string, since int64) ([]LogMessage, *Subscription, error) {
+	if logs, sub, ok := pm.subscribeBuffered(id, since); ok {
+		return logs, sub, nil
+	}
+
+	// Holding off flushes while the backlog is read ensures every message
+	// is either in storage, still buffered, or delivered to the subscription
+	pm.flushMu.Lock()
+	defer pm.flushMu.Unlock()
+
+	sub, pending, oldest := pm.subscribe(id, since)
+	// Messages before the job's buffered output are in storage or pending,
+	// and storage holds the earlier ones
+	q := LogQuery{JobID: id, From: since + 1}
+	if oldest > 0 {
+		q.Limit = int(oldest - since - 1)
+		if q.Limit <= 0 {
+			return pending, sub, nil
+		}
+	}
+	logs, err := pm.Store.QueryJobLogs(q)
+	if err != nil {
+		if sub != nil {
+			pm.Logs.Unsubscribe(sub)
+		}
+		return nil, nil, err
+	}
+	return append(logs, pending...), sub, nil
+}
+
+// subscribeBuffered registers a subscription if the job is running and its
+// buffered output includes all messages after since, and returns those
+// messages. ok is false if storage has to be read instead.
+func (pm *ProcessManager) subscribeBuffered(id string, since int64) (logs []LogMessage, sub *Subscription, ok bool) {
+	pm.Mu.RLock()
+	defer pm.Mu.RUnlock()
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+
+	job, exists := pm.Jobs[id]
+	if !exists || !job.running() {
+		return nil, nil, false
+	}
+	if logs, ok = job.logs.since(since); !ok {
+		return nil, nil, false
+	}
+	return append(logs, job.previewLogs()...), pm.Logs.Subscribe(id), true
+}
+
+// subscribe registers a subscription if the job is running, and returns
+// the job's messages that aren't in storage yet: those still waiting to be
+// written, followed by its buffered output and previews. oldest is the sequence number
+// of the oldest buffered message, zero if there is none.
+func (pm *ProcessManager) subscribe(id string, since int64) (sub *Subscription, pending []LogMessage, oldest int64) {
+	// Holding the lock guarantees the job can't finish, and close its
+	// subscriptions, before this one is registered. A stopped job may still
+	// produce output until it has exited, so its status isn't checked.
+	pm.Mu.RLock()
+	defer pm.Mu.RUnlock()
+	// Messages are numbered and published under logMu, so all messages
+	// after the buffered ones are delivered to the subscription
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+
+	job, exists := pm.Jobs[id]
+	if exists && job.running() {
+		oldest = job.logs.oldest()
+	}
+	for _, msg := range pm.logBuffer {
+		if msg.JobID == id && msg.Seq > since && (oldest == 0 || msg.Seq < oldest) {
+			pending = append(pending, msg)
+		}
+	}
+	if oldest > 0 {
+		buffered, _ := job.logs.since(max(since, oldest-1))
+		pending = append(pending, buffered...)
+	}
+
+	if !exists || !job.running() {
+		return nil, pending, oldest
+	}
+	return pm.Logs.Subscribe(id), append(pending, job.previewLogs()...), oldest
 }
 
 func (pm *ProcessManager) GetJob(id string) (*Job, error) {
@@ -211,7 +510,17 @@ func (pm *ProcessManager) ListJobs() ([]*Job, error) {
 	return pm.Store.ListJobs()
 }
 
-func (pm *ProcessManager) StopJob(id string) error {
+// StopOptions control how a running job is stopped. Zero values fall back
+// to the process manager's defaults.
+type StopOptions struct {
+	Signal      syscall.Signal // Signal sent to the job's process group first
+	GracePeriod time.Duration  // Time to wait for the job to exit before sending SIGKILL
+}
+
+// StopJob sends the stop signal to the job's process group and kills the
+// group if it hasn't exited after the grace period. It returns without
+// waiting for the job to exit.
+func (pm *ProcessManager) StopJob(id string, opts StopOptions) error {
 	pm.Mu.Lock()
 	defer pm.Mu.Unlock()
 
@@ -224,8 +533,16 @@ func (pm *ProcessManager) StopJob(id string) error {
 		return fmt.Errorf("job is not running: %s", id)
 	}
 
-	// Cancel the context and wait for process to finish
-	job.Cancel()
+	if opts.Signal == 0 {
+		opts.Signal = pm.stopSignal()
+	}
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = pm.stopGracePeriod()
+	}
+
+	if err := pm.terminate(job, opts.Signal, opts.GracePeriod); err != nil {
+		return fmt.Errorf("failed to stop job: %w", err)
+	}
 	job.Status = "stopped"
 	job.CompletedAt = time.Now()
 
@@ -237,58 +554,214 @@ func (pm *ProcessManager) StopJob(id string) error {
 	return nil
 }
 
-func (pm *ProcessManager) RestartJob(id string) (*Job, error) {
-    pm.Mu.Lock()
-    oldJob, exists := pm.Jobs[id]
-    pm.Mu.Unlock()
-
-    if !exists {
-        // Try to get the job from storage if it's not in memory
-        var err error
-        oldJob, err = pm.Store.GetJob(id)
-        if err != nil {
-            return nil, fmt.Errorf("failed to get job: %w", err)
-        }
-        if oldJob == nil {
-            return nil, fmt.Errorf("job not found: %s", id)
-        }
-    }
-
-    // Stop the old job if it's still running
-    if oldJob.Status == "running" {
-        if err := pm.StopJob(id); err != nil {
-            return nil, fmt.Errorf("failed to stop old job: %w", err)
-        }
-    }
-
-    // Start a new job with the same command
-    newJob, err := pm.StartJob(oldJob.Command)
-    if err != nil {
-        return nil, fmt.Errorf("failed to restart job: %w", err)
-    }
-
-    return newJob, nil
-}
-
-func (pm *ProcessManager) GetJobLogs(id string) []string {
+// SignalJob delivers sig to the job's process group without changing the
+// job's status.
+func (pm *ProcessManager) SignalJob(id string, sig syscall.Signal) error {
+	job, err := pm.runningJob(id)
+	if err != nil {
+		return err
+	}
+
+	if err := signalGroup(job.PID, sig); err != nil {
+		return fmt.Errorf("failed to send %s: %w", signalName(sig), err)
+	}
+	return nil
+}
+
+// WriteStdin writes data to the standard input of a running job.
+func (pm *ProcessManager) WriteStdin(id string, data []byte) error {
+	job, err := pm.runningJob(id)
+	if err != nil {
+		return err
+	}
+
+	// Writes may block until the process reads its input, so they must not
+	// happen while holding the manager's lock
+	job.stdinMu.Lock()
+	defer job.stdinMu.Unlock()
+	if job.stdin == nil {
+		return fmt.Errorf("stdin of job %s is closed", id)
+	}
+	if _, err := job.stdin.Write(data); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the standard input of a running job, so the process
+// reads EOF.
+func (pm *ProcessManager) CloseStdin(id string) error {
+	job, err := pm.runningJob(id)
+	if err != nil {
+		return err
+	}
+
+	job.stdinMu.Lock()
+	defer job.stdinMu.Unlock()
+	if job.stdin == nil {
+		return nil
+	}
+	if job.pty != nil {
+		// Closing the terminal would hang up the job, send the EOF
+		// character instead
+		if _, err := job.stdin.Write([]byte{4}); err != nil {
+			return fmt.Errorf("failed to send EOF: %w", err)
+		}
+		return nil
+	}
+	err = job.stdin.Close()
+	job.stdin = nil
+	if err != nil {
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+	return nil
+}
+
+// ResizeJob changes the terminal size of a job running in TTY mode.
+func (pm *ProcessManager) ResizeJob(id string, cols, rows uint16) error {
+	job, err := pm.runningJob(id)
+	if err != nil {
+		return err
+	}
+	if job.pty == nil {
+		return fmt.Errorf("job is not running in a terminal: %s", id)
+	}
+	if cols == 0 || rows == 0 {
+		return fmt.Errorf("invalid terminal size: %dx%d", cols, rows)
+	}
+
+	job.stdinMu.Lock()
+	defer job.stdinMu.Unlock()
+	if job.stdin == nil {
+		return fmt.Errorf("terminal of job %s is closed", id)
+	}
+	if err := setPTYSize(job.pty, cols, rows); err != nil {
+		return fmt.Errorf("failed to resize terminal: %w", err)
+	}
+	return nil
+}
+
+// runningJob returns the in-memory job with the given ID if it is running.
+func (pm *ProcessManager) runningJob(id string) (*Job, error) {
 	pm.Mu.RLock()
 	defer pm.Mu.RUnlock()
 
 	job, exists := pm.Jobs[id]
 	if !exists {
+		return nil, fmt.Errorf("job not found: %s", id)
+	}
+	if job.Status != "running" {
+		return nil, fmt.Errorf("job is not running: %s", id)
+	}
+	return job, nil
+}
+
+// running reports whether the job's process is managed by this process
+// manager and hasn't exited yet. The caller must hold the process manager's
+// Mu.
+func (j *Job) running() bool {
+	if j.done == nil {
+		return false
+	}
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// outputOpen reports whether the server is still reading the job's output.
+// Such a job can't outlive the server: writing to a pipe or terminal
+// nobody reads from gets it killed by SIGPIPE or SIGHUP.
+func (j *Job) outputOpen() bool {
+	select {
+	case <-j.outputDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// terminate sends sig to the job's process group and escalates to SIGKILL
+// if the job is still running after the grace period.
+func (pm *ProcessManager) terminate(job *Job, sig syscall.Signal, grace time.Duration) error {
+	if err := signalGroup(job.PID, sig); err != nil {
+		return err
+	}
+	if sig == syscall.SIGKILL {
 		return nil
 	}
 
-	// Convert ring buffer to slice
-	var logs []string
-	if job.LogBuffer != nil {
-		job.LogBuffer.Do(func(v interface{}) {
-			if v != nil {
-				logs = append(logs, v.(string))
+	go func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-job.done:
+		case <-timer.C:
+			fmt.Printf("Job %s did not exit within %s, sending SIGKILL\n", job.ID, grace)
+			if err := signalGroup(job.PID, syscall.SIGKILL); err != nil {
+				fmt.Printf("Failed to kill job %s: %v\n", job.ID, err)
 			}
-		})
+		}
+	}()
+	return nil
+}
+
+func (pm *ProcessManager) stopSignal() syscall.Signal {
+	if pm.StopSignal != 0 {
+		return pm.StopSignal
+	}
+	return DefaultStopSignal
+}
+
+func (pm *ProcessManager) stopGracePeriod() time.Duration {
+	if pm.StopGracePeriod > 0 {
+		return pm.StopGracePeriod
+	}
+	return DefaultStopGracePeriod
+}
+
+func (pm *ProcessManager) RestartJob(id string) (*Job, error) {
+	pm.Mu.Lock()
+	oldJob, exists := pm.Jobs[id]
+	pm.Mu.Unlock()
+
+	if !exists {
+		// Try to get the job from storage if it's not in memory
+		var err error
+		oldJob, err = pm.Store.GetJob(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+		if oldJob == nil {
+			return nil, fmt.Errorf("job not found: %s", id)
+		}
+	}
+
+	// The values of secrets aren't stored, so jobs with secrets that were
+	// loaded from storage can't be restarted
+	for _, name := range oldJob.Secrets {
+		if _, ok := oldJob.Env[name]; !ok {
+			return nil, fmt.Errorf("the value of secret %s isn't stored, create the job again instead", name)
+		}
+	}
+
+	// Stop the old job if it's still running
+	if oldJob.Status == "running" {
+		if err := pm.StopJob(id, StopOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to stop old job: %w", err)
+		}
+	}
+
+	// Start a new job with the same command, environment and working directory
+	newJob, err := pm.StartJob(oldJob.JobSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restart job: %w", err)
 	}
-	return logs
+
+	return newJob, nil
 }
 
 func (pm *ProcessManager) RemoveJob(id string) error {
@@ -315,84 +788,313 @@ func (pm *ProcessManager) RemoveJob(id string) error {
 	return nil
 }
 
-func (pm *ProcessManager) handleOutput(r io.Reader, jobID string) {
-    buffer := make([]byte, 4096)
-    for {
-        n, err := r.Read(buffer)
-        if n > 0 {
-            output := string(buffer[:n])
-            processed := ansi.Process(output)
-            msg := LogMessage{
-                JobID:   jobID,
-                Text:    processed.Plain,
-                RawText: processed.Raw,
-                Time:    time.Now(),
-            }
-
-            // Send to WebSocket
-            select {
-            case pm.LogChan <- msg:
-            default:
-                fmt.Printf("Warning: LogChan buffer full, dropping message for job %s\n", jobID)
-            }
-
-            // Store in ring buffer and log buffer
-            pm.Mu.RLock()
-            job := pm.Jobs[jobID]
-            if job != nil {
-                job.LogBuffer.Value = processed.Raw
-                job.LogBuffer = job.LogBuffer.Next()
-            }
-            pm.Mu.RUnlock()
-
-            pm.logMu.Lock()
-            pm.logBuffer = append(pm.logBuffer, msg)
-            pm.logMu.Unlock()
-        }
-        if err != nil {
-            if err != io.EOF {
-                fmt.Printf("Error reading output: %v\n", err)
-            }
-            return
-        }
-    }
-}
-
-func (pm *ProcessManager) Cleanup() {
-	pm.Mu.Lock()
-	defer pm.Mu.Unlock()
+func (pm *ProcessManager) handleOutput(r io.Reader, job *Job, stream string) {
+	redact := newRedactor(job.secretValues(), pm.RedactPatterns)
+	// Subscribers see a line while it's printed, like the updates of a
+	// progress bar, through previews without a sequence number. Only the
+	// whole line is numbered and stored, once it ends. Lines longer than
+	// MaxLineLength are numbered and stored in several parts.
+	var line strings.Builder
+	var lineStarted time.Time
+	lines := newLineSplitter(redact, func(raw string, partial bool, t time.Time) {
+		if line.Len() == 0 {
+			lineStarted = t
+		}
+		line.WriteString(raw)
+		msg := LogMessage{
+			JobID:   job.ID,
+			Stream:  stream,
+			Text:    lineText(line.String()),
+			RawText: line.String(),
+			Partial: partial,
+			Time:    lineStarted,
+		}
+		if partial && line.Len() < MaxLineLength {
+			pm.previewLog(job, msg)
+			return
+		}
+
+		line.Reset()
+		if job.JSONLogs && !partial {
+			_, msg.Level, msg.Message, msg.LoggedAt, _ = parseJSONLog(msg.Text)
+		}
+		pm.appendLog(job, msg)
+	})
+	defer lines.Close()
 
-	// Stop all running jobs
-	for _, job := range pm.Jobs {
-		if job.Status == "running" {
-			job.Cancel()
+	buffer := make([]byte, 4096)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			lines.Write(buffer[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Error reading output: %v\n", err)
+			}
+			return
 		}
 	}
+}
 
-	// Flush remaining logs
-	pm.flushLogs()
+// previewLog publishes the part of an unfinished line printed so far to
+// subscribers, without a sequence number. It replaces the line's previous
+// preview for clients that subscribe later.
+func (pm *ProcessManager) previewLog(job *Job, msg LogMessage) {
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+
+	if job.previews == nil {
+		job.previews = make(map[string]LogMessage)
+	}
+	job.previews[msg.Stream] = msg
+	pm.Logs.Publish(msg)
+}
+
+// appendLog numbers a log message of a job, publishes it to subscribers and
+// buffers it for storage and the sinks.
+func (pm *ProcessManager) appendLog(job *Job, msg LogMessage) {
+	// Numbering, publishing and buffering happen under one lock, so
+	// subscribers and storage see messages in sequence order
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+
+	job.lastSeq++
+	msg.Seq = job.lastSeq
+	delete(job.previews, msg.Stream)
+
+	// Send to live subscribers
+	pm.Logs.Publish(msg)
+
+	// Keep in memory for clients catching up, and buffer for storage
+	job.logs.push(msg)
+	pm.logBuffer = append(pm.logBuffer, msg)
+
+	// Forward to the sinks, which drop messages rather than block
+	for _, sink := range pm.sinks {
+		sink.enqueue(sinkEntry{log: msg})
+	}
+
+	// Collect workflow commands into the job's metadata, which is stored
+	// with the next flush
+	if event, ok := ParseCommand(msg); ok {
+		job.applyEvent(event)
+		if !job.dirty {
+			job.dirty = true
+			pm.dirtyJobs = append(pm.dirtyJobs, job)
+		}
+	}
+}
 
-	// Close log channel
-	close(pm.LogChan)
+// JobSpec describes how a job's command is invoked. It is stored with the
+// job so a restart reproduces the same invocation.
+type JobSpec struct {
+	Command  string            // Shell command, run with sh -c
+	Env      map[string]string // Extra environment variables
+	CleanEnv bool              // Start from an empty environment instead of inheriting the server's
+	Cwd      string            // Working directory, empty for the server's current directory
+	Timeout  time.Duration     // Maximum run time, zero for the server default
+	TTY      bool              // Run attached to a pseudo-terminal instead of pipes
+	Secrets  []string          // Names of variables in Env whose values are redacted from output and API responses
+	JSONLogs bool              // Parse output lines holding JSON objects as structured logs
+	// Bytes of recent output kept in memory while the job runs, zero for
+	// the server default
+	LogBufferSize int64
+}
+
+// Validate checks that the spec can be used to start a job.
+func (s JobSpec) Validate() error {
+	if strings.TrimSpace(s.Command) == "" {
+		return fmt.Errorf("command must not be empty")
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if s.LogBufferSize < 0 || s.LogBufferSize > MaxLogBufferSize {
+		return fmt.Errorf("log buffer size must be between 0 and %d bytes", MaxLogBufferSize)
+	}
+	if s.TTY && !ptySupported {
+		return errPTYUnsupported
+	}
+	for key := range s.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("invalid environment variable name: %q", key)
+		}
+	}
+	for _, name := range s.Secrets {
+		if _, ok := s.Env[name]; !ok {
+			return fmt.Errorf("secret %q is not one of the job's environment variables", name)
+		}
+	}
+	return nil
+}
+
+// secretValues returns the values of the job's secret environment
+// variables.
+func (s JobSpec) secretValues() []string {
+	var values []string
+	for _, name := range s.Secrets {
+		if value := s.Env[name]; value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// storedEnv returns the environment variables to store with the job. The
+// values of secrets are left out, so they are only kept in memory.
+func (s JobSpec) storedEnv() map[string]string {
+	env := make(map[string]string, len(s.Env))
+	for key, value := range s.Env {
+		env[key] = value
+	}
+	for _, name := range s.Secrets {
+		delete(env, name)
+	}
+	return env
+}
+
+// environ builds the environment for the job's process.
+func (s JobSpec) environ() []string {
+	var env []string
+	if !s.CleanEnv {
+		env = os.Environ()
+	}
+	if s.TTY {
+		// Programs need TERM to use the terminal, the job's own variables
+		// can still override it
+		env = append(env, "TERM=xterm-256color")
+	}
+
+	// Sort keys so the resulting environment is deterministic
+	keys := make([]string, 0, len(s.Env))
+	for key := range s.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+s.Env[key])
+	}
+
+	// A nil Env makes exec inherit the server's environment, so a clean
+	// environment without variables must be an empty, non-nil slice
+	if env == nil {
+		env = []string{}
+	}
+	return env
 }
 
 type Job struct {
+	JobSpec
+
 	ID          string
 	Cmd         *exec.Cmd
-	Command     string // Store command string directly
-	PID         int    // Process ID
+	PID         int // Process ID
 	Cancel      context.CancelFunc
-	Status      string // running, stopped, completed
+	Status      string // running, stopped, completed, failed, timeout, lost
 	StartedAt   time.Time
-	CompletedAt time.Time  // When the job finished (success or failure)
-	LogBuffer   *ring.Ring // 1000 elements
+	CompletedAt time.Time      // When the job finished (success or failure)
+	ExitCode    *int           // Exit code, nil while running or when killed by a signal
+	Signal      string         // Name of the signal that terminated the process, if any
+	Reason      string         // Why the job ended, when it wasn't by exiting normally
+	Pinned      bool           // Exempt from the retention policy
+	done        chan struct{}  // Closed once the process has exited and its final status is stored
+	outputDone  chan struct{}  // Closed once the server has read all of the job's output
+	stdin       io.WriteCloser // Pipe or terminal the job reads its input from
+	stdinMu     sync.Mutex
+	pty         *os.File              // Master side of the job's terminal in TTY mode
+	lastSeq     int64                 // Sequence number of the last log message, guarded by the process manager's logMu
+	logs        *logRing              // Recent output while running, nil if none is kept, guarded by the process manager's logMu
+	previews    map[string]LogMessage // Unfinished line of each stream, guarded by the process manager's logMu
+	metadata    atomic.Pointer[JobMetadata]
+	dirty       bool // Metadata changed since it was last stored, guarded by the process manager's logMu
+}
+
+// previewLogs returns the previews of the lines the job is printing, in
+// stream order. The caller must hold the process manager's logMu.
+func (j *Job) previewLogs() []LogMessage {
+	var previews []LogMessage
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		if msg, ok := j.previews[stream]; ok {
+			previews = append(previews, msg)
+		}
+	}
+	return previews
 }
 
+// outputSource is a reader for one of a job's output streams.
+type outputSource struct {
+	r      io.Reader
+	stream string
+}
+
+// Output streams a log message can originate from. The values match the
+// log_level column in job_logs.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// LogMessage is a line of a job's output. Logs written before output was
+// split into lines may hold any number of lines.
 type LogMessage struct {
 	JobID   string
-	Text    string // Plain text without ANSI codes
-	RawText string // Original text with ANSI codes
+	Seq     int64  // Position in the job's output, starting at 1, zero for previews of unfinished lines
+	Stream  string // stdout or stderr
+	Text    string // Plain text without ANSI codes and line terminator
+	RawText string // Original text with ANSI codes and line terminator
+	Partial bool   // The line continues in the next message of the stream
+	Time    time.Time
+
+	// Fields of a JSON log line, for jobs in JSON logs mode
+	Level    string    // Normalized level, one of LogLevels
+	Message  string    // Message of the line
+	LoggedAt time.Time // Time the line was logged, as recorded in the line
+}
+
+// LogQuery selects the logs of a job. Zero values don't restrict the
+// result.
+type LogQuery struct {
+	JobID  string
+	Stream string    // Only logs from this stream
+	From   int64     // First sequence number
+	Start  time.Time // Only logs at or after this time
+	End    time.Time // Only logs before this time
+	Limit  int       // Maximum number of logs, counted from the first match
+	Tail   int       // Only the last matching logs, takes precedence over Limit
+	Level  string    // Only JSON log lines of at least this level
+}
+
+// DefaultSearchLimit is the number of matches returned by a search without
+// a limit.
+const DefaultSearchLimit = 100
+
+// SearchQuery is a full-text search across the logs of all jobs.
+type SearchQuery struct {
+	Query  string    // Words that must all appear in a line, a trailing * matches a prefix
+	Status string    // Only jobs with this status
+	Start  time.Time // Only lines printed at or after this time
+	End    time.Time // Only lines printed before this time
+	Limit  int       // Maximum number of matches
+}
+
+// SearchMatch is a log line matching a search.
+type SearchMatch struct {
+	JobID   string
+	Seq     int64
+	Stream  string
 	Time    time.Time
+	Snippet string // HTML escaped text around the match, with matching words in <mark> tags
+}
+
+// matches reports whether a log satisfies the query's conditions, apart
+// from its limits.
+func (q LogQuery) matches(log LogMessage) bool {
+	return (q.Stream == "" || log.Stream == q.Stream) &&
+		log.Seq >= q.From &&
+		(q.Start.IsZero() || !log.Time.Before(q.Start)) &&
+		(q.End.IsZero() || log.Time.Before(q.End)) &&
+		(q.Level == "" || LevelAtLeast(log.Level, q.Level))
 }
 
 type Storage interface {
@@ -401,6 +1103,14 @@ type Storage interface {
 	ListJobs() ([]*Job, error)
 	RemoveJob(id string) error
 	BatchWriteLogs(logs []LogMessage) error
-	GetJobLogs(id string) ([]LogMessage, error)
+	QueryJobLogs(q LogQuery) ([]LogMessage, error)
+	SearchLogs(q SearchQuery) ([]SearchMatch, error)
+	SetJobPinned(id string, pinned bool) error
+	UpdateJobMetadata(id string, metadata *JobMetadata) error
+	JobLogSizes() (map[string]int64, error)
+	CompactJobLogs(id string) error
+	TruncateJobLogs(id string, maxBytes int64) (lines int64, bytes int64, err error)
+	DatabaseSize() (int64, error)
 	UpdateJobStatus(id string, status string) error
+	FinishJob(job *Job) error
 }
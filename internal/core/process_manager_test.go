@@ -0,0 +1,465 @@
+package core
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitForLogs waits until n log messages of a job were stored.
+func waitForLogs(t *testing.T, s Storage, id string, n int) []LogMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs, err := s.QueryJobLogs(LogQuery{JobID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) >= n {
+			return logs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job stored %d log messages, want %d", len(logs), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutputStreams(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	job, err := pm.StartJob(JobSpec{Command: "echo out; echo err >&2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streams := make(map[string]string)
+	for _, log := range waitForLogs(t, pm.Store, job.ID, 2) {
+		streams[log.Stream] += log.RawText
+	}
+	if streams[StreamStdout] != "out\n" || streams[StreamStderr] != "err\n" {
+		t.Errorf("got stdout %q and stderr %q, want %q and %q", streams[StreamStdout], streams[StreamStderr], "out\n", "err\n")
+	}
+}
+
+// waitForJob waits until a job finished and its status was stored, and
+// returns the stored job.
+func waitForJob(t *testing.T, s Storage, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := s.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != "running" {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecordExit(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	tests := []struct {
+		command string
+		status  string
+		code    int // -1 for none
+		signal  string
+	}{
+		{"true", "completed", 0, ""},
+		{"exit 3", "failed", 3, ""},
+		{"kill -TERM $$", "failed", -1, "SIGTERM"},
+		{"kill -9 $$", "failed", -1, "SIGKILL"},
+	}
+	for _, tt := range tests {
+		job, err := pm.StartJob(JobSpec{Command: tt.command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := waitForJob(t, pm.Store, job.ID)
+
+		code := -1
+		if stored.ExitCode != nil {
+			code = *stored.ExitCode
+		}
+		if stored.Status != tt.status || code != tt.code || stored.Signal != tt.signal {
+			t.Errorf("%q: got status %s, exit code %d, signal %q, want %s, %d, %q", tt.command, stored.Status, code, stored.Signal, tt.status, tt.code, tt.signal)
+		}
+	}
+}
+
+// jobOutput waits until a job finished, and returns its stored stdout.
+func jobOutput(t *testing.T, s Storage, id string) string {
+	t.Helper()
+	waitForJob(t, s, id)
+	logs, err := s.QueryJobLogs(LogQuery{JobID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	for _, log := range logs {
+		if log.Stream == StreamStdout {
+			out += log.RawText
+		}
+	}
+	return out
+}
+
+func TestJobSpecValidate(t *testing.T) {
+	for _, spec := range []JobSpec{
+		{Command: " "},
+		{Command: "env", Env: map[string]string{"": "value"}},
+		{Command: "env", Env: map[string]string{"A=B": "value"}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", spec)
+		}
+	}
+	if err := (JobSpec{Command: "env", Env: map[string]string{"A": ""}}).Validate(); err != nil {
+		t.Errorf("got %v for an empty value, want no error", err)
+	}
+}
+
+func TestJobEnvironmentAndCwd(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SRUN_TEST_SERVER", "inherited")
+	pm := NewProcessManager(newTestStorage(t))
+
+	const command = `pwd; echo "$GREETING ${SRUN_TEST_SERVER-unset}"`
+	tests := []struct {
+		spec JobSpec
+		want string
+	}{
+		{JobSpec{Command: command}, "*\n inherited\n"},
+		{JobSpec{Command: command, Env: map[string]string{"GREETING": "hello"}, Cwd: dir}, dir + "\nhello inherited\n"},
+		{JobSpec{Command: command, Env: map[string]string{"GREETING": "hello"}, CleanEnv: true, Cwd: dir}, dir + "\nhello unset\n"},
+	}
+	for _, tt := range tests {
+		job, err := pm.StartJob(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := jobOutput(t, pm.Store, job.ID)
+		if tt.spec.Cwd == "" {
+			// Runs in the server's directory
+			wd, _ := os.Getwd()
+			tt.want = strings.Replace(tt.want, "*", wd, 1)
+		}
+		if out != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.spec, out, tt.want)
+		}
+
+		// A restart reproduces the invocation from the stored job
+		restarted, err := NewProcessManager(pm.Store).RestartJob(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := jobOutput(t, pm.Store, restarted.ID); out != tt.want {
+			t.Errorf("%+v: restarted job printed %q, want %q", tt.spec, out, tt.want)
+		}
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	pm.DefaultTimeout = 200 * time.Millisecond
+	tests := []struct {
+		spec   JobSpec
+		status string
+	}{
+		{JobSpec{Command: "echo started; sleep 5"}, "timeout"},
+		{JobSpec{Command: "echo started; sleep 5", Timeout: 300 * time.Millisecond}, "timeout"},
+		// A job's own timeout overrides the default
+		{JobSpec{Command: "echo started; sleep 0.5", Timeout: 5 * time.Second}, "completed"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		job, err := pm.StartJob(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := jobOutput(t, pm.Store, job.ID)
+		stored := waitForJob(t, pm.Store, job.ID)
+		if stored.Status != tt.status || out != "started\n" {
+			t.Errorf("%+v: got status %s and output %q after %v, want %s and %q", tt.spec, stored.Status, out, time.Since(start), tt.status, "started\n")
+		}
+		// Timeouts are stored in whole seconds
+		if want := tt.spec.Timeout.Truncate(time.Second); stored.Timeout != want {
+			t.Errorf("%+v: stored timeout %v, want %v", tt.spec, stored.Timeout, want)
+		}
+	}
+}
+
+// waitForExit waits until a job's processes exited and its output was read.
+func waitForExit(t *testing.T, job *Job) {
+	t.Helper()
+	select {
+	case <-job.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job didn't exit")
+	}
+}
+
+func TestStopJob(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	tests := []struct {
+		name    string
+		command string
+		opts    StopOptions
+		output  string
+		signal  string
+	}{
+		{
+			// The background sleep is stopped along with the shell
+			name:    "default signal",
+			command: `trap 'echo stopping; exit 0' TERM; echo ready; sleep 30 & wait`,
+			output:  "ready\nstopping\n",
+		},
+		{
+			name:    "custom signal",
+			command: `trap 'echo interrupted; exit 0' INT; echo ready; sleep 30; echo unreachable`,
+			opts:    StopOptions{Signal: syscall.SIGINT},
+			output:  "ready\ninterrupted\n",
+		},
+		{
+			// Children inherit the ignored signal, so the whole group has
+			// to be killed
+			name:    "grace period",
+			command: `trap '' TERM; echo ready; sleep 30`,
+			opts:    StopOptions{GracePeriod: 200 * time.Millisecond},
+			output:  "ready\n",
+			signal:  "SIGKILL",
+		},
+	}
+	for _, tt := range tests {
+		job, err := pm.StartJob(JobSpec{Command: tt.command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForLogs(t, pm.Store, job.ID, 1)
+
+		start := time.Now()
+		if err := pm.StopJob(job.ID, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		waitForExit(t, job)
+		if tt.opts.GracePeriod > 0 && time.Since(start) < tt.opts.GracePeriod {
+			t.Errorf("%s: job was killed after %v, before the grace period", tt.name, time.Since(start))
+		}
+		pm.Mu.RLock()
+		status, signal := job.Status, job.Signal
+		pm.Mu.RUnlock()
+		if status != "stopped" || signal != tt.signal {
+			t.Errorf("%s: got status %s and signal %q, want stopped and %q", tt.name, status, signal, tt.signal)
+		}
+
+		var out string
+		for _, log := range waitForLogs(t, pm.Store, job.ID, strings.Count(tt.output, "\n")) {
+			out += log.RawText
+		}
+		if out != tt.output {
+			t.Errorf("%s: got output %q, want %q", tt.name, out, tt.output)
+		}
+	}
+
+	if err := pm.StopJob("missing", StopOptions{}); err == nil {
+		t.Error("stopping an unknown job succeeded")
+	}
+}
+
+func TestUnfinishedLinesArePreviewed(t *testing.T) {
+	store := newTestStorage(t)
+	pm := NewProcessManager(store)
+	job := &Job{ID: "job", JobSpec: JobSpec{Command: "progress"}, Status: "running", StartedAt: time.Now(), logs: newLogRing(DefaultLogBufferSize)}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+	sub := pm.Logs.Subscribe("job")
+	defer pm.Logs.Unsubscribe(sub)
+
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pm.handleOutput(r, job, StreamStdout)
+		close(done)
+	}()
+	for _, part := range []string{"\r10%", "\r50%", "\r100%\n", "done\n"} {
+		w.Write([]byte(part))
+		time.Sleep(2 * partialLineDelay)
+	}
+	w.Close()
+	<-done
+
+	// Subscribers saw every update, as previews of the whole line so far
+	want := []LogMessage{
+		{Seq: 0, RawText: "\r10%", Partial: true},
+		{Seq: 0, RawText: "\r10%\r50%", Partial: true},
+		{Seq: 1, RawText: "\r10%\r50%\r100%\n"},
+		{Seq: 2, RawText: "done\n"},
+	}
+	var live []LogMessage
+	for len(sub.C) > 0 {
+		live = append(live, <-sub.C)
+	}
+	if len(live) != len(want) {
+		t.Fatalf("got %d live messages, want %d: %+v", len(live), len(want), live)
+	}
+	for i, msg := range live {
+		if msg.Seq != want[i].Seq || msg.RawText != want[i].RawText || msg.Partial != want[i].Partial {
+			t.Errorf("live message %d = %+v, want %+v", i, msg, want[i])
+		}
+	}
+	if !live[2].Time.Equal(live[0].Time) {
+		t.Errorf("line time = %v, want the time of its first preview %v", live[2].Time, live[0].Time)
+	}
+
+	// Only the whole lines are numbered, buffered and stored
+	pm.logMu.Lock()
+	buffered, _ := job.logs.since(0)
+	pm.logMu.Unlock()
+	pm.flushLogs()
+	stored, err := pm.QueryLogs(LogQuery{JobID: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, logs := range map[string][]LogMessage{"buffered": buffered, "stored": stored} {
+		if len(logs) != 2 {
+			t.Errorf("got %d %s lines, want 2: %+v", len(logs), name, logs)
+			continue
+		}
+		for i, log := range logs {
+			if log.Seq != want[i+2].Seq || log.RawText != want[i+2].RawText || log.Partial {
+				t.Errorf("%s line %d = %+v, want %+v", name, i, log, want[i+2])
+			}
+		}
+		if logs[0].Text != "100%" {
+			t.Errorf("%s line text = %q, want %q", name, logs[0].Text, "100%")
+		}
+	}
+}
+
+func TestSubscribeLogsMidLine(t *testing.T) {
+	for _, bufferSize := range []int64{DefaultLogBufferSize, 0} {
+		pm := NewProcessManager(newTestStorage(t))
+		pm.LogBufferSize = bufferSize
+		job, err := pm.StartJob(JobSpec{Command: `echo one; printf 'Name: '; read name; echo "hello $name"`})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Wait for the prompt, and for the first line to be stored when
+		// there's no buffer to serve it from
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			pm.logMu.Lock()
+			_, prompted := job.previews[StreamStdout]
+			pm.logMu.Unlock()
+			if prompted {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("job didn't print its prompt")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		pm.flushLogs()
+
+		// A client that saw the first line and resumes while the prompt is
+		// shown gets a preview of the prompt, then the whole line once it
+		// ends, without gaps in the sequence numbers
+		logs, sub, err := pm.SubscribeLogs(job.ID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub == nil {
+			t.Fatal("no subscription to a running job")
+		}
+		if len(logs) != 1 || logs[0].Seq != 0 || logs[0].RawText != "Name: " {
+			t.Errorf("buffer size %d: resuming mid-line got %+v, want a preview of the prompt", bufferSize, logs)
+		}
+		if err := pm.WriteStdin(job.ID, []byte("srun\n")); err != nil {
+			t.Fatal(err)
+		}
+		var line LogMessage
+		for msg := range sub.C {
+			if msg.Seq > 0 {
+				line = msg
+				break
+			}
+		}
+		pm.Logs.Unsubscribe(sub)
+		<-job.done
+
+		if line.Seq != 2 || line.RawText != "Name: hello srun\n" {
+			t.Errorf("buffer size %d: got line %d %q, want line 2 %q", bufferSize, line.Seq, line.RawText, "Name: hello srun\n")
+		}
+		logs, _, err = pm.SubscribeLogs(job.ID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 1 || logs[0].Seq != 2 || logs[0].RawText != line.RawText {
+			t.Errorf("buffer size %d: resuming after the job finished got %+v, want line 2", bufferSize, logs)
+		}
+	}
+}
+
+func TestSubscribeLogsBackfill(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	// Room for the last lines only, the earlier ones are read from storage
+	pm.LogBufferSize = 20 * (logEntryOverhead + 8)
+	job, err := pm.StartJob(JobSpec{Command: "seq 1 200; sleep 30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pm.StopJob(job.ID, StopOptions{Signal: syscall.SIGKILL})
+		<-job.done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pm.logMu.Lock()
+		last := job.lastSeq
+		pm.logMu.Unlock()
+		if last == 200 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job printed %d lines, want 200", last)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, since := range []int64{0, 150, 190, 200} {
+		logs, sub, err := pm.SubscribeLogs(job.ID, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub == nil {
+			t.Fatal("no subscription to a running job")
+		}
+		pm.Logs.Unsubscribe(sub)
+
+		if len(logs) != int(200-since) {
+			t.Errorf("since %d: got %d lines, want %d", since, len(logs), 200-since)
+			continue
+		}
+		for i, log := range logs {
+			if want := since + int64(i) + 1; log.Seq != want || log.Text != strconv.FormatInt(want, 10) {
+				t.Errorf("since %d: got line %d %q, want %d", since, log.Seq, log.Text, want)
+				break
+			}
+		}
+	}
+}
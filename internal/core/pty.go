@@ -0,0 +1,34 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// Initial size of a job's terminal, until a client sends its own.
+const (
+	defaultPTYCols = 80
+	defaultPTYRows = 24
+)
+
+// errPTYUnsupported is returned for jobs that ask for a terminal on
+// platforms without pseudo-terminal support.
+var errPTYUnsupported = fmt.Errorf("terminal mode is not supported on %s", runtime.GOOS)
+
+// ptyReader reads from the master side of a pseudo-terminal. Linux reports
+// EIO once every slave descriptor is closed, which is translated to EOF.
+type ptyReader struct {
+	f *os.File
+}
+
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
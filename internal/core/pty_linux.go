@@ -0,0 +1,60 @@
+//go:build linux
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptySupported reports whether jobs can run in a pseudo-terminal.
+const ptySupported = true
+
+// openPTY allocates a pseudo-terminal pair. The master side stays with the
+// server, the slave side becomes the job's controlling terminal.
+func openPTY() (master, slave *os.File, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+	master = os.NewFile(uintptr(fd), "/dev/ptmx")
+
+	// Unlock the slave side and look up its name
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err = os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+
+	return master, slave, nil
+}
+
+// setPTYSize sets the window size of the terminal, which also delivers
+// SIGWINCH to its foreground process group.
+func setPTYSize(f *os.File, cols, rows uint16) error {
+	return unix.IoctlSetWinsize(int(f.Fd()), unix.TIOCSWINSZ, &unix.Winsize{
+		Col: cols,
+		Row: rows,
+	})
+}
+
+// setControllingTerminal makes cmd start in a new session whose controlling
+// terminal is its stdin.
+func setControllingTerminal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+}
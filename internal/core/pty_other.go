@@ -0,0 +1,25 @@
+//go:build !linux
+
+package core
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ptySupported reports whether jobs can run in a pseudo-terminal.
+const ptySupported = false
+
+// openPTY fails, as pseudo-terminals are only supported on Linux.
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errPTYUnsupported
+}
+
+// setPTYSize fails, as pseudo-terminals are only supported on Linux.
+func setPTYSize(f *os.File, cols, rows uint16) error {
+	return errPTYUnsupported
+}
+
+// setControllingTerminal does nothing, as pseudo-terminals are only
+// supported on Linux.
+func setControllingTerminal(cmd *exec.Cmd) {}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// How often adopted jobs are checked for having exited.
+const adoptPollInterval = time.Second
+
+// ReconcileJobs resolves jobs that storage lists as running but that this
+// process manager didn't start, which happens when the server restarts
+// while jobs are running. Jobs whose process is still alive are adopted so
+// they can be stopped and signalled, the rest are marked as lost.
+func (pm *ProcessManager) ReconcileJobs() error {
+	jobs, err := pm.Store.ListJobs()
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if job.Status != "running" {
+			continue
+		}
+
+		pm.Mu.RLock()
+		_, managed := pm.Jobs[job.ID]
+		pm.Mu.RUnlock()
+		if managed {
+			continue
+		}
+
+		if ok, why := isJobProcess(job); !ok {
+			job.Status = "lost"
+			job.CompletedAt = time.Now()
+			job.Reason = "server restarted while the job was running: " + why
+			fmt.Printf("Marking job %s as lost: %s\n", job.ID, why)
+			if err := pm.Store.FinishJob(job); err != nil {
+				return fmt.Errorf("failed to mark job %s as lost: %w", job.ID, err)
+			}
+			if err := pm.Store.CompactJobLogs(job.ID); err != nil {
+				fmt.Printf("Failed to compact logs: %v\n", err)
+			}
+			continue
+		}
+
+		fmt.Printf("Adopting job %s with PID %d\n", job.ID, job.PID)
+		pm.adopt(job)
+	}
+
+	return nil
+}
+
+// adopt starts tracking a job whose process survived a server restart. The
+// process isn't our child, so its output and exit status are not available,
+// but it can still be stopped and signalled through its process group.
+func (pm *ProcessManager) adopt(job *Job) {
+	job.done = make(chan struct{})
+	// The output of an adopted job doesn't go through the server
+	job.outputDone = make(chan struct{})
+	close(job.outputDone)
+	job.Cancel = func() {
+		pm.terminate(job, pm.stopSignal(), pm.stopGracePeriod())
+	}
+
+	pm.Mu.Lock()
+	pm.Jobs[job.ID] = job
+	pm.Mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(adoptPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if ok, _ := isJobProcess(job); !ok {
+				break
+			}
+		}
+
+		pm.Mu.Lock()
+		job.CompletedAt = time.Now()
+		if job.Status != "stopped" {
+			job.Status = "lost"
+			job.Reason = "process exited after a server restart, its exit status is unknown"
+		}
+		pm.Mu.Unlock()
+
+		if err := pm.Store.FinishJob(job); err != nil {
+			fmt.Printf("Failed to update job status: %v\n", err)
+		}
+		if err := pm.Store.CompactJobLogs(job.ID); err != nil {
+			fmt.Printf("Failed to compact logs: %v\n", err)
+		}
+
+		pm.Mu.Lock()
+		pm.Logs.CloseJob(job.ID)
+		close(job.done)
+		pm.Mu.Unlock()
+	}()
+}
+
+// isJobProcess reports whether the job's PID still belongs to the process
+// that was started for it. If not, the second return value explains why.
+func isJobProcess(job *Job) (bool, string) {
+	if job.PID <= 0 {
+		return false, "no process ID was recorded"
+	}
+	if !processExists(job.PID) {
+		return false, fmt.Sprintf("process %d no longer exists", job.PID)
+	}
+	return identifyProcess(job)
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How far the start time of a process may be from the job's recorded start
+// time for the process to still be considered the job's.
+const adoptStartTolerance = 5 * time.Second
+
+// identifyProcess reports whether the running process with the job's PID
+// is the one that was started for it, using /proc. If not, the second
+// return value explains why.
+func identifyProcess(job *Job) (bool, string) {
+	stat, err := readProcStat(job.PID)
+	if err != nil {
+		return false, fmt.Sprintf("process %d no longer exists", job.PID)
+	}
+	if stat.state == "Z" {
+		return false, fmt.Sprintf("process %d has exited", job.PID)
+	}
+
+	// The PID may have been reused. The command line can't be compared,
+	// because the shell may have replaced itself with the command, but
+	// jobs always lead their own process group and the start time must
+	// match what was recorded for the job.
+	if stat.pgrp != job.PID {
+		return false, fmt.Sprintf("process %d belongs to a different process group", job.PID)
+	}
+	if diff := stat.started.Sub(job.StartedAt); diff < -adoptStartTolerance || diff > adoptStartTolerance {
+		return false, fmt.Sprintf("process %d was started at a different time", job.PID)
+	}
+
+	return true, ""
+}
+
+// clockTicks is the unit of process start times in /proc, USER_HZ, which is
+// 100 on every architecture Linux supports.
+const clockTicks = 100
+
+// procStat holds the fields of /proc/<pid>/stat used to identify a process.
+type procStat struct {
+	state   string
+	pgrp    int
+	started time.Time
+}
+
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// The command name in field 2 may contain spaces, so fields are counted
+	// from the closing parenthesis: state is field 3, the process group
+	// field 5 and the start time field 22.
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, fmt.Errorf("malformed stat file")
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return procStat{}, fmt.Errorf("malformed stat file")
+	}
+
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed process group: %w", err)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed start time: %w", err)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return procStat{}, err
+	}
+
+	return procStat{
+		state:   fields[0],
+		pgrp:    pgrp,
+		started: boot.Add(time.Duration(ticks) * time.Second / clockTicks),
+	}, nil
+}
+
+// bootTime reads the system boot time from /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("malformed boot time: %w", err)
+		}
+		return time.Unix(secs, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("boot time not found in /proc/stat")
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactionMask replaces secrets in job output and API responses.
+const RedactionMask = "***"
+
+// DefaultRedactPatterns match common credentials in job output. Only the
+// first group of a pattern with groups is redacted, so the text identifying
+// the credential remains readable.
+var DefaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),                                    // AWS access key IDs
+	regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+]{40})`), // AWS secret access keys
+	regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),                           // Bearer tokens
+}
+
+// maxHoldback limits how much of a partial line is held back because it may
+// be the start of a secret.
+const maxHoldback = MaxLineLength / 2
+
+// ParseRedactPattern compiles a regular expression matching secrets to
+// redact from job output.
+func ParseRedactPattern(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern %q matches empty text", expr)
+	}
+	return re, nil
+}
+
+// redactor masks secrets in job output: the values of a job's secret
+// environment variables and text matching the server's patterns.
+type redactor struct {
+	secrets  []string
+	replacer *strings.Replacer
+	patterns []*regexp.Regexp
+	progs    []*syntax.Prog // Compiled patterns, to find matches that have only begun
+}
+
+// newRedactor returns a redactor for the given secret values and patterns,
+// or nil if there is nothing to redact.
+func newRedactor(secrets []string, patterns []*regexp.Regexp) *redactor {
+	if len(secrets) == 0 && len(patterns) == 0 {
+		return nil
+	}
+
+	// Longer secrets go first, so one containing another is masked whole
+	secrets = append([]string(nil), secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var pairs []string
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, RedactionMask)
+	}
+	var progs []*syntax.Prog
+	for _, pattern := range patterns {
+		re, err := syntax.Parse(pattern.String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+		if prog, err := syntax.Compile(re.Simplify()); err == nil {
+			progs = append(progs, prog)
+		}
+	}
+	return &redactor{
+		secrets:  secrets,
+		replacer: strings.NewReplacer(pairs...),
+		patterns: patterns,
+		progs:    progs,
+	}
+}
+
+// redact masks the secrets in s.
+func (r *redactor) redact(s string) string {
+	if len(r.secrets) > 0 {
+		s = r.replacer.Replace(s)
+	}
+	for _, pattern := range r.patterns {
+		matches := pattern.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(RedactionMask)
+			last = end
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
+
+// holdback returns the length of the end of s that may be the start of a
+// secret whose rest hasn't been written yet: a prefix of a secret value, a
+// pattern match that may continue, or text that may still become a match.
+func (r *redactor) holdback(s string) int {
+	n := 0
+	for _, secret := range r.secrets {
+		for k := min(len(secret)-1, len(s)); k > n; k-- {
+			if strings.HasSuffix(s, secret[:k]) {
+				n = k
+				break
+			}
+		}
+	}
+	for _, pattern := range r.patterns {
+		matches := pattern.FindAllStringIndex(s, -1)
+		if len(matches) > 0 && matches[len(matches)-1][1] == len(s) {
+			n = max(n, len(s)-matches[len(matches)-1][0])
+		}
+	}
+	// Matches starting further back than maxHoldback can't be held anyway
+	from := max(len(s)-maxHoldback, 0)
+	for from < len(s) && !utf8.RuneStart(s[from]) {
+		from++
+	}
+	for _, prog := range r.progs {
+		n = max(n, len(s)-unfinishedMatch(prog, s, from))
+	}
+	return min(n, maxHoldback)
+}
+
+// unfinishedMatch returns the start of the earliest match of prog that
+// begins at or after from and has reached the end of s without failing, so
+// the text that follows may complete it. It returns len(s) if there is
+// none. Completed matches aren't reported.
+func unfinishedMatch(prog *syntax.Prog, s string, from int) int {
+	// A simulation of the program's NFA, tracking where each thread
+	// started. Threads are kept in order of their start, and one that
+	// reaches an instruction another thread is already at is dropped, as
+	// the earlier one can match anything it can.
+	type thread struct {
+		pc    uint32
+		start int
+	}
+	visited := make([]bool, len(prog.Inst))
+	var add func(list []thread, pc uint32, start int, ctx syntax.EmptyOp, end bool) []thread
+	add = func(list []thread, pc uint32, start int, ctx syntax.EmptyOp, end bool) []thread {
+		if visited[pc] {
+			return list
+		}
+		visited[pc] = true
+		switch inst := &prog.Inst[pc]; inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			list = add(list, inst.Out, start, ctx, end)
+			list = add(list, inst.Arg, start, ctx, end)
+		case syntax.InstCapture, syntax.InstNop:
+			list = add(list, inst.Out, start, ctx, end)
+		case syntax.InstEmptyWidth:
+			// What follows the end of s isn't known, so any assertion
+			// may hold there
+			if end || syntax.EmptyOp(inst.Arg)&^ctx == 0 {
+				list = add(list, inst.Out, start, ctx, end)
+			}
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+			list = append(list, thread{pc, start})
+		}
+		return list
+	}
+
+	var pending []thread // Threads that consumed the previous character
+	for i := from; ; {
+		prev, next, width := rune(-1), rune(-1), 0
+		if i > 0 {
+			prev, _ = utf8.DecodeLastRuneInString(s[:i])
+		}
+		end := i == len(s)
+		if !end {
+			next, width = utf8.DecodeRuneInString(s[i:])
+		}
+		ctx := syntax.EmptyOpContext(prev, next)
+
+		clear(visited)
+		var list []thread
+		for _, t := range pending {
+			list = add(list, t.pc, t.start, ctx, end)
+		}
+		list = add(list, uint32(prog.Start), i, ctx, end)
+
+		if end {
+			if len(list) == 0 {
+				return len(s)
+			}
+			return list[0].start
+		}
+
+		pending = pending[:0]
+		for _, t := range list {
+			inst := &prog.Inst[t.pc]
+			var ok bool
+			switch inst.Op {
+			case syntax.InstRuneAny:
+				ok = true
+			case syntax.InstRuneAnyNotNL:
+				ok = next != '\n'
+			default:
+				ok = inst.MatchRune(next)
+			}
+			if ok {
+				pending = append(pending, thread{inst.Out, t.start})
+			}
+		}
+		i += width
+	}
+}
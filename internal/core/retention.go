@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy limits how much job history is kept. Zero values disable
+// a limit. Running and pinned jobs are never removed or truncated.
+type RetentionPolicy struct {
+	MaxAge         time.Duration // Remove finished jobs that ended longer ago
+	MaxJobs        int           // Keep at most this many finished jobs, removing the oldest
+	MaxJobLogBytes int64         // Truncate longer logs of finished jobs, keeping their head and tail
+	MaxDBBytes     int64         // Remove the oldest finished jobs while the database is larger
+}
+
+// Enabled reports whether the policy limits anything.
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxJobs > 0 || p.MaxJobLogBytes > 0 || p.MaxDBBytes > 0
+}
+
+// RetentionReport describes what applying the retention policy removed.
+type RetentionReport struct {
+	Time          time.Time
+	RemovedJobs   []string // Jobs removed with their logs
+	TruncatedJobs []string // Jobs whose logs were truncated
+	RemovedLines  int64    // Log lines removed by truncation
+	RemovedBytes  int64    // Log bytes removed by truncation
+	DBBytes       int64    // Database size afterwards
+	Error         string   // Why applying the policy failed, if it did
+}
+
+// RunJanitor applies the process manager's retention policy every interval
+// until ctx is done.
+func (pm *ProcessManager) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report := pm.ApplyRetention()
+		if report.Error != "" {
+			fmt.Printf("Error applying retention policy: %s\n", report.Error)
+		} else if len(report.RemovedJobs) > 0 || len(report.TruncatedJobs) > 0 {
+			fmt.Printf("Retention policy removed %d jobs and truncated the logs of %d jobs (%d lines, %d bytes), database size %d bytes\n",
+				len(report.RemovedJobs), len(report.TruncatedJobs), report.RemovedLines, report.RemovedBytes, report.DBBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastRetentionReport returns the report of the last time the retention
+// policy was applied, or nil if it hasn't been yet.
+func (pm *ProcessManager) LastRetentionReport() *RetentionReport {
+	pm.retentionMu.Lock()
+	defer pm.retentionMu.Unlock()
+	return pm.lastRetention
+}
+
+// ApplyRetention removes and truncates finished jobs according to the
+// process manager's retention policy.
+func (pm *ProcessManager) ApplyRetention() *RetentionReport {
+	report := &RetentionReport{Time: time.Now()}
+	if err := pm.applyRetention(pm.Retention, report); err != nil {
+		report.Error = err.Error()
+	}
+
+	pm.retentionMu.Lock()
+	pm.lastRetention = report
+	pm.retentionMu.Unlock()
+	return report
+}
+
+func (pm *ProcessManager) applyRetention(policy RetentionPolicy, report *RetentionReport) error {
+	if !policy.Enabled() {
+		return nil
+	}
+
+	jobs, err := pm.Store.ListJobs()
+	if err != nil {
+		return err
+	}
+
+	// Jobs that may be removed, newest first
+	var candidates []*Job
+	for _, job := range jobs {
+		if job.Status != "running" && !job.Pinned && !pm.isActive(job.ID) {
+			candidates = append(candidates, job)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return jobEnd(candidates[i]).After(jobEnd(candidates[j]))
+	})
+
+	remove := func(job *Job) error {
+		if err := pm.RemoveJob(job.ID); err != nil {
+			return err
+		}
+		report.RemovedJobs = append(report.RemovedJobs, job.ID)
+		return nil
+	}
+
+	// Too old or too many
+	var kept []*Job
+	for i, job := range candidates {
+		tooOld := policy.MaxAge > 0 && time.Since(jobEnd(job)) > policy.MaxAge
+		tooMany := policy.MaxJobs > 0 && i >= policy.MaxJobs
+		if !tooOld && !tooMany {
+			kept = append(kept, job)
+			continue
+		}
+		if err := remove(job); err != nil {
+			return err
+		}
+	}
+
+	// Logs that are too large
+	if policy.MaxJobLogBytes > 0 {
+		sizes, err := pm.Store.JobLogSizes()
+		if err != nil {
+			return err
+		}
+		for _, job := range kept {
+			if sizes[job.ID] <= policy.MaxJobLogBytes {
+				continue
+			}
+			lines, bytes, err := pm.Store.TruncateJobLogs(job.ID, policy.MaxJobLogBytes)
+			if err != nil {
+				return err
+			}
+			if lines > 0 {
+				report.TruncatedJobs = append(report.TruncatedJobs, job.ID)
+				report.RemovedLines += lines
+				report.RemovedBytes += bytes
+			}
+		}
+	}
+
+	// A database that is too large, oldest jobs go first
+	size, err := pm.Store.DatabaseSize()
+	if err != nil {
+		return err
+	}
+	for policy.MaxDBBytes > 0 && size > policy.MaxDBBytes && len(kept) > 0 {
+		if err := remove(kept[len(kept)-1]); err != nil {
+			return err
+		}
+		kept = kept[:len(kept)-1]
+		if size, err = pm.Store.DatabaseSize(); err != nil {
+			return err
+		}
+	}
+	report.DBBytes = size
+	return nil
+}
+
+// isActive reports whether a job's process may still be running, even if
+// it has already been marked as stopped.
+func (pm *ProcessManager) isActive(id string) bool {
+	pm.Mu.RLock()
+	defer pm.Mu.RUnlock()
+
+	job, exists := pm.Jobs[id]
+	if !exists || job.done == nil {
+		return false
+	}
+	select {
+	case <-job.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// jobEnd returns when a finished job ended, falling back to its start for
+// jobs without a recorded end.
+func jobEnd(job *Job) time.Time {
+	if job.CompletedAt.IsZero() {
+		return job.StartedAt
+	}
+	return job.CompletedAt
+}
+
+// PinJob sets whether a job is exempt from the retention policy.
+func (pm *ProcessManager) PinJob(id string, pinned bool) error {
+	if err := pm.Store.SetJobPinned(id, pinned); err != nil {
+		return err
+	}
+
+	pm.Mu.Lock()
+	defer pm.Mu.Unlock()
+	if job, exists := pm.Jobs[id]; exists {
+		job.Pinned = pinned
+	}
+	return nil
+}
+
+// ParseByteSize parses a size in bytes with an optional K, M or G suffix,
+// e.g. "512K" or "10MB". Suffixes are powers of 1024.
+func ParseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GIB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30},
+		{"MIB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20},
+		{"KIB", 1 << 10}, {"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * multiplier, nil
+}
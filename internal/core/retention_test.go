@@ -0,0 +1,142 @@
+package core
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storeFinishedJob stores a job that ended at the given time, with lines of
+// output.
+func storeFinishedJob(t *testing.T, s *SQLiteStorage, id, status string, ended time.Time, lines int) {
+	t.Helper()
+	job := &Job{ID: id, JobSpec: JobSpec{Command: "test"}, Status: status, StartedAt: ended.Add(-time.Minute), CompletedAt: ended}
+	if err := s.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+	var logs []LogMessage
+	for i := 0; i < lines; i++ {
+		line := strings.Repeat("x", 99) + "\n"
+		logs = append(logs, LogMessage{JobID: id, Seq: int64(i + 1), Stream: StreamStdout, Text: lineText(line), RawText: line, Time: job.StartedAt})
+	}
+	if err := s.BatchWriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedJobIDs returns the IDs of the stored jobs, sorted.
+func storedJobIDs(t *testing.T, s *SQLiteStorage) []string {
+	t.Helper()
+	jobs, err := s.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRetentionSparesPinnedAndRunningJobs(t *testing.T) {
+	s := newTestStorage(t)
+	pm := NewProcessManager(s)
+	old := time.Now().Add(-48 * time.Hour)
+	storeFinishedJob(t, s, "old", "completed", old, 1)
+	storeFinishedJob(t, s, "pinned", "completed", old, 1)
+	storeFinishedJob(t, s, "running", "running", old, 1)
+	// A stopped job whose process hasn't exited yet
+	storeFinishedJob(t, s, "stopping", "stopped", old, 1)
+	if err := pm.PinJob("pinned", true); err != nil {
+		t.Fatal(err)
+	}
+	pm.Jobs["stopping"] = &Job{ID: "stopping", Status: "stopped", done: make(chan struct{})}
+
+	// Every limit applies, yet none of them removes these jobs
+	pm.Retention = RetentionPolicy{MaxAge: time.Hour, MaxJobs: 1, MaxJobLogBytes: 1, MaxDBBytes: 1}
+	report := pm.ApplyRetention()
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+
+	if !slices.Equal(report.RemovedJobs, []string{"old"}) || len(report.TruncatedJobs) != 0 {
+		t.Errorf("removed %v and truncated %v, want only the old job removed", report.RemovedJobs, report.TruncatedJobs)
+	}
+	if got, want := storedJobIDs(t, s), []string{"pinned", "running", "stopping"}; !slices.Equal(got, want) {
+		t.Errorf("kept jobs %v, want %v", got, want)
+	}
+}
+
+func TestRetentionOrder(t *testing.T) {
+	s := newTestStorage(t)
+	pm := NewProcessManager(s)
+	now := time.Now()
+	storeFinishedJob(t, s, "1m", "completed", now.Add(-time.Minute), 100)
+	storeFinishedJob(t, s, "2m", "failed", now.Add(-2*time.Minute), 1)
+	storeFinishedJob(t, s, "3m", "stopped", now.Add(-3*time.Minute), 1)
+	storeFinishedJob(t, s, "3h", "completed", now.Add(-3*time.Hour), 1)
+
+	// Age and count limits go first, then large logs are truncated
+	pm.Retention = RetentionPolicy{MaxAge: time.Hour, MaxJobs: 2, MaxJobLogBytes: 1000}
+	report := pm.ApplyRetention()
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if !slices.Equal(report.RemovedJobs, []string{"3m", "3h"}) {
+		t.Errorf("removed %v, want the job over the count limit and the old one", report.RemovedJobs)
+	}
+	if !slices.Equal(report.TruncatedJobs, []string{"1m"}) || report.RemovedLines == 0 {
+		t.Errorf("truncated %v removing %d lines, want the logs of the newest job truncated", report.TruncatedJobs, report.RemovedLines)
+	}
+	logs, err := pm.QueryLogs(LogQuery{JobID: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) >= 100 {
+		t.Errorf("kept %d of 100 lines", len(logs))
+	}
+
+	// The database size limit removes the oldest of the remaining jobs
+	// first, until the database is small enough
+	pm.Retention = RetentionPolicy{MaxDBBytes: 1}
+	report = pm.ApplyRetention()
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if !slices.Equal(report.RemovedJobs, []string{"2m", "1m"}) {
+		t.Errorf("removed %v, want the oldest job first", report.RemovedJobs)
+	}
+	if got := storedJobIDs(t, s); len(got) != 0 {
+		t.Errorf("kept jobs %v, want none", got)
+	}
+	if report.DBBytes == 0 {
+		t.Error("report doesn't hold the database size")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"4K", 4 << 10},
+		{"10MB", 10 << 20},
+		{"1 GiB", 1 << 30},
+		{"2g", 2 << 30},
+	}
+	for _, tt := range tests {
+		if got, err := ParseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "K", "-1", "1T", "1.5M"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ShutdownPolicy decides what happens to running jobs when the server shuts
+// down.
+type ShutdownPolicy string
+
+const (
+	// ShutdownWait waits for running jobs to finish, and stops the ones
+	// still running when the shutdown timeout expires.
+	ShutdownWait ShutdownPolicy = "wait"
+	// ShutdownStop stops running jobs gracefully.
+	ShutdownStop ShutdownPolicy = "stop"
+	// ShutdownDetach leaves running jobs alone if their output no longer
+	// goes through the server, like daemons that redirected it, and stops
+	// the others and TTY jobs, which would be killed by SIGPIPE or SIGHUP
+	// once the server exits. Detached jobs are adopted when the server
+	// starts again.
+	ShutdownDetach ShutdownPolicy = "detach"
+)
+
+// How long to wait for jobs to be reaped after they were killed because the
+// shutdown timeout expired.
+const shutdownKillWait = 5 * time.Second
+
+// ParseShutdownPolicy validates a shutdown policy name.
+func ParseShutdownPolicy(name string) (ShutdownPolicy, error) {
+	switch policy := ShutdownPolicy(name); policy {
+	case ShutdownWait, ShutdownStop, ShutdownDetach:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown shutdown policy %q, expected wait, stop or detach", name)
+	}
+}
+
+// Shutdown applies the policy to running jobs, then stops the background
+// log writer and flushes pending logs. Jobs still running when ctx expires
+// are killed, except the ones left detached.
+func (pm *ProcessManager) Shutdown(ctx context.Context, policy ShutdownPolicy) error {
+	running := pm.runningJobs()
+
+	var stop []*Job
+	reason := "stopped by server shutdown"
+	switch policy {
+	case ShutdownDetach:
+		var detached []*Job
+		for _, job := range running {
+			// A terminal hangs up its session when the server closes it,
+			// even if the job redirected its output
+			if job.outputOpen() || job.pty != nil {
+				stop = append(stop, job)
+			} else {
+				detached = append(detached, job)
+			}
+		}
+		if len(detached) > 0 {
+			fmt.Printf("Leaving %d running jobs detached\n", len(detached))
+		}
+		if len(stop) > 0 {
+			fmt.Printf("Stopping %d running jobs whose output can't be detached\n", len(stop))
+		}
+		running = stop
+		reason = "stopped by server shutdown, its output is read by the server and can't be detached"
+
+	case ShutdownStop:
+		stop = running
+		fmt.Printf("Stopping %d running jobs\n", len(running))
+
+	case ShutdownWait:
+		if len(running) > 0 {
+			fmt.Printf("Waiting for %d running jobs to finish\n", len(running))
+		}
+
+	default:
+		return fmt.Errorf("unknown shutdown policy %q", policy)
+	}
+
+	for _, job := range stop {
+		pm.setReason(job, reason)
+		if err := pm.StopJob(job.ID, StopOptions{}); err != nil {
+			fmt.Printf("Failed to stop job %s: %v\n", job.ID, err)
+		}
+	}
+
+	remaining := waitForJobs(ctx, running)
+	if len(remaining) > 0 {
+		fmt.Printf("Killing %d jobs still running after the shutdown timeout\n", len(remaining))
+		for _, job := range remaining {
+			pm.setReason(job, "killed by server shutdown")
+			if err := pm.StopJob(job.ID, StopOptions{Signal: syscall.SIGKILL}); err != nil {
+				// The job may already be stopping, kill it regardless
+				signalGroup(job.PID, syscall.SIGKILL)
+			}
+		}
+
+		killCtx, cancel := context.WithTimeout(context.Background(), shutdownKillWait)
+		defer cancel()
+		waitForJobs(killCtx, remaining)
+	}
+
+	close(pm.stopLogs)
+	pm.flushLogs()
+	return nil
+}
+
+// runningJobs returns the jobs managed by this process manager that are
+// still running.
+func (pm *ProcessManager) runningJobs() []*Job {
+	pm.Mu.RLock()
+	defer pm.Mu.RUnlock()
+
+	var running []*Job
+	for _, job := range pm.Jobs {
+		if job.Status == "running" {
+			running = append(running, job)
+		}
+	}
+	return running
+}
+
+func (pm *ProcessManager) setReason(job *Job, reason string) {
+	pm.Mu.Lock()
+	defer pm.Mu.Unlock()
+	job.Reason = reason
+}
+
+// waitForJobs waits until the given jobs have finished or ctx expires, and
+// returns the jobs that are still running.
+func waitForJobs(ctx context.Context, jobs []*Job) []*Job {
+	for i, job := range jobs {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			var remaining []*Job
+			for _, job := range jobs[i:] {
+				select {
+				case <-job.done:
+				default:
+					remaining = append(remaining, job)
+				}
+			}
+			return remaining
+		}
+	}
+	return nil
+}
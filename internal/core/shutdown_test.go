@@ -0,0 +1,46 @@
+package core
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownDetachStopsJobsWithOpenOutput(t *testing.T) {
+	pm := NewProcessManager(newTestStorage(t))
+	piped, err := pm.StartJob(JobSpec{Command: "sleep 30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := pm.StartJob(JobSpec{Command: "exec sleep 30 </dev/null >/dev/null 2>&1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		signalGroup(daemon.PID, syscall.SIGKILL)
+		<-daemon.done
+	}()
+
+	select {
+	case <-daemon.outputDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server kept reading output of a job that closed it")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pm.Shutdown(ctx, ShutdownDetach); err != nil {
+		t.Fatal(err)
+	}
+
+	pm.Mu.RLock()
+	running := daemon.running()
+	if piped.Status != "stopped" || piped.Reason == "" {
+		t.Errorf("job with piped output: status %q, reason %q, want it stopped", piped.Status, piped.Reason)
+	}
+	pm.Mu.RUnlock()
+	if !running {
+		t.Error("job without output was stopped, want it left running")
+	}
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalName returns the conventional name of a signal, e.g. "SIGTERM".
+func signalName(sig syscall.Signal) string {
+	if name := lookupSignalName(sig); name != "" {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// ParseSignal parses a signal given by name ("SIGTERM", "TERM", "term") or
+// number ("15").
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.TrimSpace(name)
+	if num, err := strconv.Atoi(name); err == nil {
+		sig := syscall.Signal(num)
+		if num <= 0 || lookupSignalName(sig) == "" {
+			return 0, fmt.Errorf("unknown signal: %s", name)
+		}
+		return sig, nil
+	}
+
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	sig := lookupSignal(upper)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal: %s", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,86 @@
+//go:build !unix
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// signals are the signals the syscall package defines on platforms without
+// POSIX signals.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGILL":  syscall.SIGILL,
+	"SIGTRAP": syscall.SIGTRAP,
+	"SIGABRT": syscall.SIGABRT,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGPIPE": syscall.SIGPIPE,
+	"SIGALRM": syscall.SIGALRM,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// lookupSignalName returns the name of a signal, or "" if the platform
+// doesn't define it.
+func lookupSignalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return ""
+}
+
+// lookupSignal returns the signal with the given name, e.g. "SIGTERM", or 0
+// if the platform doesn't define it.
+func lookupSignal(name string) syscall.Signal {
+	return signals[name]
+}
+
+// signalGroup stops the process with the given PID. Without process groups
+// and POSIX signals, only the process itself can be reached, and it is
+// killed by any of the signals that would terminate it. Other signals can't
+// be delivered.
+func signalGroup(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid process id: %d", pid)
+	}
+	switch sig {
+	case syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM:
+	default:
+		return fmt.Errorf("%s can't be delivered on %s", signalName(sig), runtime.GOOS)
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// The process no longer exists
+		return nil
+	}
+	defer p.Release()
+	if err := p.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// setProcessGroup does nothing, as the platform has no process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// processExists reports whether a process with the given PID exists.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"SIGHUP", "HUP", "hup", " SIGhup ", "1"} {
+		sig, err := ParseSignal(name)
+		if err != nil || sig != syscall.SIGHUP {
+			t.Errorf("ParseSignal(%q) = %v, %v, want SIGHUP", name, sig, err)
+		}
+	}
+	for _, name := range []string{"", "SIG", "SIGNOPE", "0", "-1", "1000"} {
+		if sig, err := ParseSignal(name); err == nil {
+			t.Errorf("ParseSignal(%q) = %v, want an error", name, sig)
+		}
+	}
+	if name := signalName(syscall.SIGTERM); name != "SIGTERM" {
+		t.Errorf("got name %q, want SIGTERM", name)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// lookupSignalName returns the name of a signal, or "" if the platform
+// doesn't define it.
+func lookupSignalName(sig syscall.Signal) string {
+	return unix.SignalName(sig)
+}
+
+// lookupSignal returns the signal with the given name, e.g. "SIGTERM", or 0
+// if the platform doesn't define it.
+func lookupSignal(name string) syscall.Signal {
+	return unix.SignalNum(name)
+}
+
+// signalGroup sends sig to every process in the process group led by pid.
+// A group that no longer exists is not an error.
+func signalGroup(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid process id: %d", pid)
+	}
+	if err := syscall.Kill(-pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// setProcessGroup makes cmd start in its own process group, so stopping the
+// job also reaches any children started by the shell.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// processExists reports whether a process with the given PID exists.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSinkBuffer is the number of log messages buffered for each sink.
+const DefaultSinkBuffer = 10000
+
+// Limits on how a sink's buffer is drained.
+const (
+	maxSinkBatch      = 1000            // Messages passed to one WriteLogs call
+	sinkDropReportGap = 1 * time.Minute // Minimum time between reports of dropped messages
+)
+
+// LogSink receives the output of all jobs, in addition to storage, to keep
+// it somewhere else like files or a log server.
+type LogSink interface {
+	// WriteLogs receives log messages in the order they were written, which
+	// is sequence order within each job.
+	WriteLogs(logs []LogMessage) error
+	// JobFinished is called after the last message of a job.
+	JobFinished(jobID string) error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// sinkEntry is a log message queued for a sink, or the end of a job's
+// output if finished is set.
+type sinkEntry struct {
+	log      LogMessage
+	finished string
+}
+
+// bufferedSink feeds a sink from its own goroutine, so a slow sink never
+// blocks the job. Messages are buffered up to a limit, beyond which new
+// messages are dropped and counted rather than slowing down the output
+// pipeline.
+type bufferedSink struct {
+	name string
+	sink LogSink
+
+	mu         sync.Mutex
+	queue      []sinkEntry
+	size       int
+	dropped    int // Messages dropped since the last report
+	lastReport time.Time
+	closed     bool
+
+	wake chan struct{} // Signals that the queue isn't empty
+	done chan struct{} // Closed once the goroutine has drained the queue
+}
+
+func newBufferedSink(name string, sink LogSink, size int) *bufferedSink {
+	if size <= 0 {
+		size = DefaultSinkBuffer
+	}
+	s := &bufferedSink{
+		name: name,
+		sink: sink,
+		size: size,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// enqueue adds an entry to the queue without blocking. Log messages are
+// dropped while the queue is full, the end of a job never is.
+func (s *bufferedSink) enqueue(entry sinkEntry) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if entry.finished == "" && len(s.queue) >= s.size {
+		s.dropped++
+		s.mu.Unlock()
+		return
+	}
+	s.queue = append(s.queue, entry)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued entries to the sink until the sink is closed and its
+// queue is empty.
+func (s *bufferedSink) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		n := min(len(s.queue), maxSinkBatch)
+		batch := append([]sinkEntry(nil), s.queue[:n]...)
+		s.queue = s.queue[n:]
+		if len(s.queue) == 0 {
+			s.queue = nil
+		}
+		closed := s.closed
+		s.reportDropped()
+		s.mu.Unlock()
+
+		if len(batch) == 0 {
+			if closed {
+				return
+			}
+			<-s.wake
+			continue
+		}
+		s.write(batch)
+	}
+}
+
+// write passes a batch of entries to the sink, splitting the log messages
+// at the end of each job.
+func (s *bufferedSink) write(batch []sinkEntry) {
+	var logs []LogMessage
+	flush := func() {
+		if len(logs) == 0 {
+			return
+		}
+		if err := s.sink.WriteLogs(logs); err != nil {
+			fmt.Printf("Log sink %s failed to write %d messages: %v\n", s.name, len(logs), err)
+		}
+		logs = logs[:0]
+	}
+
+	for _, entry := range batch {
+		if entry.finished == "" {
+			logs = append(logs, entry.log)
+			continue
+		}
+		flush()
+		if err := s.sink.JobFinished(entry.finished); err != nil {
+			fmt.Printf("Log sink %s failed to finish job %s: %v\n", s.name, entry.finished, err)
+		}
+	}
+	flush()
+}
+
+// reportDropped prints how many messages were dropped, at most once per
+// sinkDropReportGap. The caller must hold s.mu.
+func (s *bufferedSink) reportDropped() {
+	if s.dropped == 0 || time.Since(s.lastReport) < sinkDropReportGap {
+		return
+	}
+	fmt.Printf("Log sink %s is too slow, dropped %d messages\n", s.name, s.dropped)
+	s.dropped = 0
+	s.lastReport = time.Now()
+}
+
+// close writes the queued entries and closes the sink. If ctx ends first,
+// the remaining entries are abandoned.
+func (s *bufferedSink) close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for buffered messages to be written: %w", ctx.Err())
+	}
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped > 0 {
+		fmt.Printf("Log sink %s dropped %d messages\n", s.name, dropped)
+	}
+	return s.sink.Close()
+}
+
+// AddSink feeds the output of all jobs to a sink, buffering up to
+// bufferSize messages for it. Sinks must be added before jobs are started.
+func (pm *ProcessManager) AddSink(name string, sink LogSink, bufferSize int) {
+	pm.sinks = append(pm.sinks, newBufferedSink(name, sink, bufferSize))
+}
+
+// CloseSinks writes the messages still buffered for the sinks and closes
+// them, giving up on sinks that haven't caught up when ctx ends. Output of
+// detached jobs printed later is ignored.
+func (pm *ProcessManager) CloseSinks(ctx context.Context) {
+	for _, s := range pm.sinks {
+		if err := s.close(ctx); err != nil {
+			fmt.Printf("Failed to close log sink %s: %v\n", s.name, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recordingSink records the calls it receives, blocking writes until
+// released.
+type recordingSink struct {
+	mu      sync.Mutex
+	release chan struct{}
+	calls   []string
+}
+
+func (s *recordingSink) WriteLogs(logs []LogMessage) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, log := range logs {
+		s.calls = append(s.calls, fmt.Sprintf("%s:%d", log.JobID, log.Seq))
+	}
+	return nil
+}
+
+func (s *recordingSink) JobFinished(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "finished "+jobID)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "close")
+	return nil
+}
+
+func TestBufferedSinkDropsWhenFull(t *testing.T) {
+	sink := &recordingSink{release: make(chan struct{})}
+	s := newBufferedSink("test", sink, 2)
+
+	// The first message may already be taken by the sink, which blocks,
+	// so it's written before the queue fills up
+	s.enqueue(sinkEntry{log: LogMessage{JobID: "a", Seq: 1}})
+	for seq := int64(2); seq <= 10; seq++ {
+		s.enqueue(sinkEntry{log: LogMessage{JobID: "a", Seq: seq}})
+	}
+	s.enqueue(sinkEntry{finished: "a"})
+	// Every message that didn't fit is counted
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	close(sink.release)
+
+	if err := s.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.enqueue(sinkEntry{log: LogMessage{JobID: "a", Seq: 11}})
+
+	if len(sink.calls) < 4 || len(sink.calls) > 5 {
+		t.Fatalf("got calls %q, want 2 or 3 messages, the end of the job and close", sink.calls)
+	}
+	tail := sink.calls[len(sink.calls)-2:]
+	if !slices.Equal(tail, []string{"finished a", "close"}) {
+		t.Errorf("got calls %q, want the end of the job and close last", sink.calls)
+	}
+	if sink.calls[0] != "a:1" {
+		t.Errorf("got calls %q, want the first message written", sink.calls)
+	}
+	if written := len(sink.calls) - 2; written+dropped != 10 {
+		t.Errorf("wrote %d messages and counted %d as dropped, want 10 in total", written, dropped)
+	}
+}
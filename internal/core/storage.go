@@ -1,14 +1,14 @@
 package core
 
 import (
-	"container/ring"
-	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"html"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"srun/internal/ansi"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -25,15 +25,41 @@ func (s *SQLiteStorage) CreateJob(job *Job) error {
 		stoppedAt = job.CompletedAt
 	}
 
+	var env interface{}
+	if stored := job.storedEnv(); len(stored) > 0 {
+		encoded, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("failed to encode job environment: %w", err)
+		}
+		env = string(encoded)
+	}
+	var secrets interface{}
+	if len(job.Secrets) > 0 {
+		encoded, err := json.Marshal(job.Secrets)
+		if err != nil {
+			return fmt.Errorf("failed to encode job secrets: %w", err)
+		}
+		secrets = string(encoded)
+	}
+
+	// The log key identifies the job's lines in the search index
 	_, err := s.db.Exec(
-		`INSERT INTO jobs (id, command, pid, status, created_at, stopped_at) 
-         VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO jobs (id, command, pid, status, created_at, stopped_at, env, clean_env, cwd, timeout_seconds, tty, secrets, json_logs, log_buffer_size, log_key) 
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(log_key), 0) + 1 FROM jobs))`,
 		job.ID,
 		job.Command,
 		job.PID,
 		job.Status,
 		job.StartedAt,
 		stoppedAt,
+		env,
+		job.CleanEnv,
+		job.Cwd,
+		int64(job.Timeout/time.Second),
+		job.TTY,
+		secrets,
+		job.JSONLogs,
+		job.LogBufferSize,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create job: %w", err)
@@ -42,14 +68,15 @@ func (s *SQLiteStorage) CreateJob(job *Job) error {
 	return nil
 }
 
-func (s *SQLiteStorage) GetJob(id string) (*Job, error) {
-	row := s.db.QueryRow(
-		`SELECT id, command, pid, status, created_at, stopped_at 
-         FROM jobs 
-         WHERE id = ?`,
-		id,
-	)
+// jobColumns lists the columns read by scanJob, in scan order.
+const jobColumns = `id, command, pid, status, created_at, stopped_at, exit_code, signal, env, clean_env, cwd, timeout_seconds, tty, reason, pinned, secrets, metadata, json_logs, log_buffer_size`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+func scanJob(row rowScanner) (*Job, error) {
 	var (
 		jobID     string
 		command   string
@@ -57,31 +84,83 @@ func (s *SQLiteStorage) GetJob(id string) (*Job, error) {
 		status    string
 		createdAt time.Time
 		stoppedAt sql.NullTime
+		exitCode  sql.NullInt64
+		signal    sql.NullString
+		env       sql.NullString
+		cleanEnv  bool
+		cwd       sql.NullString
+		timeout   int64
+		tty       bool
+		reason    sql.NullString
+		pinned    bool
+		secrets   sql.NullString
+		metadata  sql.NullString
+		jsonLogs  bool
+		logBuffer int64
 	)
 
-	if err := row.Scan(&jobID, &command, &pid, &status, &createdAt, &stoppedAt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to scan job: %w", err)
+	if err := row.Scan(&jobID, &command, &pid, &status, &createdAt, &stoppedAt, &exitCode, &signal, &env, &cleanEnv, &cwd, &timeout, &tty, &reason, &pinned, &secrets, &metadata, &jsonLogs, &logBuffer); err != nil {
+		return nil, err
 	}
 
-	// Create a new Job struct with the retrieved data
 	job := &Job{
+		JobSpec: JobSpec{
+			Command:       command,
+			CleanEnv:      cleanEnv,
+			Cwd:           cwd.String,
+			Timeout:       time.Duration(timeout) * time.Second,
+			TTY:           tty,
+			JSONLogs:      jsonLogs,
+			LogBufferSize: logBuffer,
+		},
 		ID:          jobID,
-		Command:     command,
 		PID:         pid,
 		Status:      status,
 		StartedAt:   createdAt,
 		CompletedAt: stoppedAt.Time,
-		LogBuffer:   ring.New(1000),
+		Signal:      signal.String,
+		Reason:      reason.String,
+		Pinned:      pinned,
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		job.ExitCode = &code
+	}
+	if env.Valid && env.String != "" {
+		if err := json.Unmarshal([]byte(env.String), &job.Env); err != nil {
+			return nil, fmt.Errorf("failed to decode environment of job %s: %w", jobID, err)
+		}
 	}
+	if secrets.Valid && secrets.String != "" {
+		if err := json.Unmarshal([]byte(secrets.String), &job.Secrets); err != nil {
+			return nil, fmt.Errorf("failed to decode secrets of job %s: %w", jobID, err)
+		}
+	}
+	if metadata.Valid && metadata.String != "" {
+		var m JobMetadata
+		if err := json.Unmarshal([]byte(metadata.String), &m); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata of job %s: %w", jobID, err)
+		}
+		job.metadata.Store(&m)
+	}
+
+	return job, nil
+}
+
+func (s *SQLiteStorage) GetJob(id string) (*Job, error) {
+	row := s.db.QueryRow(
+		`SELECT `+jobColumns+` 
+         FROM jobs 
+         WHERE id = ?`,
+		id,
+	)
 
-	// Only create Cmd if job is not completed/stopped
-	if status == "running" {
-		ctx, cancel := context.WithCancel(context.Background())
-		job.Cancel = cancel
-		job.Cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	job, err := scanJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to scan job: %w", err)
 	}
 
 	return job, nil
@@ -107,7 +186,7 @@ func (s *SQLiteStorage) RemoveJob(id string) error {
 
 func (s *SQLiteStorage) ListJobs() ([]*Job, error) {
 	rows, err := s.db.Query(
-		`SELECT id, command, pid, status, created_at, stopped_at 
+		`SELECT ` + jobColumns + ` 
          FROM jobs 
          ORDER BY created_at DESC`,
 	)
@@ -118,36 +197,10 @@ func (s *SQLiteStorage) ListJobs() ([]*Job, error) {
 
 	var jobs []*Job
 	for rows.Next() {
-		var (
-			jobID     string
-			command   string
-			pid       int
-			status    string
-			createdAt time.Time
-			stoppedAt sql.NullTime
-		)
-
-		if err := rows.Scan(&jobID, &command, &pid, &status, &createdAt, &stoppedAt); err != nil {
+		job, err := scanJob(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan job row: %w", err)
 		}
-
-		job := &Job{
-			ID:          jobID,
-			Command:     command,
-			PID:         pid,
-			Status:      status,
-			StartedAt:   createdAt,
-			CompletedAt: stoppedAt.Time,
-			LogBuffer:   ring.New(1000),
-		}
-
-		// Only create Cmd if job is not completed/stopped
-		if status == "running" {
-			ctx, cancel := context.WithCancel(context.Background())
-			job.Cancel = cancel
-			job.Cmd = exec.CommandContext(ctx, "sh", "-c", command)
-		}
-
 		jobs = append(jobs, job)
 	}
 
@@ -159,8 +212,6 @@ func (s *SQLiteStorage) ListJobs() ([]*Job, error) {
 }
 
 func (s *SQLiteStorage) BatchWriteLogs(logs []LogMessage) error {
-	fmt.Printf("Starting batch write of %d logs\n", len(logs))
-
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -168,31 +219,53 @@ func (s *SQLiteStorage) BatchWriteLogs(logs []LogMessage) error {
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-        INSERT INTO job_logs (job_id, content, log_level, created_at)
-        VALUES (?, ?, ?, ?)
+        INSERT INTO job_logs (job_id, seq, content, log_level, partial, created_at, level, message, logged_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
     `)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
-	for i, log := range logs {
-		fmt.Printf("Writing log %d/%d for job %s\n", i+1, len(logs), log.JobID)
+	// The plain text of each line is indexed for search
+	searchStmt, err := tx.Prepare(`
+        INSERT INTO job_logs_fts (rowid, text, job_id, seq, stream, time)
+        SELECT (log_key << 32) + ?, ?, id, ?, ?, ?
+        FROM jobs
+        WHERE id = ?
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to prepare search statement: %w", err)
+	}
+	defer searchStmt.Close()
+
+	for _, log := range logs {
+		stream := log.Stream
+		if stream == "" {
+			stream = StreamStdout
+		}
 		_, err = stmt.Exec(
 			log.JobID,
+			log.Seq,
 			log.RawText,
-			"stdout", // TODO: Add proper log level detection
-			log.Time,
+			stream,
+			log.Partial,
+			log.Time.UTC(),
+			nullString(log.Level),
+			nullString(log.Message),
+			nullUnixNano(log.LoggedAt),
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert log: %w", err)
 		}
+		if _, err := searchStmt.Exec(log.Seq, log.Text, log.Seq, stream, log.Time.UnixNano(), log.JobID); err != nil {
+			return fmt.Errorf("failed to index log: %w", err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
-	fmt.Printf("Successfully wrote %d logs to database\n", len(logs))
 	return nil
 }
 
@@ -211,14 +284,178 @@ func (s *SQLiteStorage) UpdateJobStatus(id string, status string) error {
 	return nil
 }
 
-func (s *SQLiteStorage) GetJobLogs(jobID string) ([]LogMessage, error) {
-	rows, err := s.db.Query(`
-        SELECT content, created_at 
-        FROM job_logs 
-        WHERE job_id = ? 
-        ORDER BY created_at ASC`,
-		jobID,
+func (s *SQLiteStorage) FinishJob(job *Job) error {
+	var exitCode interface{}
+	if job.ExitCode != nil {
+		exitCode = *job.ExitCode
+	}
+	var signal interface{}
+	if job.Signal != "" {
+		signal = job.Signal
+	}
+	var reason interface{}
+	if job.Reason != "" {
+		reason = job.Reason
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE jobs 
+         SET status = ?, 
+             stopped_at = ?, 
+             exit_code = ?, 
+             signal = ?, 
+             reason = ?
+         WHERE id = ?`,
+		job.Status,
+		job.CompletedAt,
+		exitCode,
+		signal,
+		reason,
+		job.ID,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) SetJobPinned(id string, pinned bool) error {
+	result, err := s.db.Exec(`UPDATE jobs SET pinned = ? WHERE id = ?`, pinned, id)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("job not found: %s", id)
+	}
+	return nil
+}
+
+// nullString converts an empty string to NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullUnixNano converts a time to Unix nanoseconds, or the zero time to
+// NULL.
+func nullUnixNano(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+// UpdateJobMetadata stores what a job has reported through workflow
+// commands.
+func (s *SQLiteStorage) UpdateJobMetadata(id string, metadata *JobMetadata) error {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode job metadata: %w", err)
+	}
+	if _, err := s.db.Exec(`UPDATE jobs SET metadata = ? WHERE id = ?`, string(encoded), id); err != nil {
+		return fmt.Errorf("failed to update job metadata: %w", err)
+	}
+	return nil
+}
+
+// QueryJobLogs returns the logs of a job matching q, in sequence order.
+func (s *SQLiteStorage) QueryJobLogs(q LogQuery) ([]LogMessage, error) {
+	// Both kinds of storage are read in one transaction, so logs being
+	// compacted are seen exactly once
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	compacted, err := queryBlockLogs(tx, q)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := queryRowLogs(tx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(compacted) == 0 {
+		return logs, nil
+	}
+	if len(logs) == 0 {
+		return compacted, nil
+	}
+
+	// Merge the two, both are in sequence order and limited
+	merged := make([]LogMessage, 0, len(compacted)+len(logs))
+	for len(compacted) > 0 || len(logs) > 0 {
+		if len(logs) == 0 || (len(compacted) > 0 && compacted[0].Seq <= logs[0].Seq) {
+			merged, compacted = append(merged, compacted[0]), compacted[1:]
+		} else {
+			merged, logs = append(merged, logs[0]), logs[1:]
+		}
+	}
+	switch {
+	case q.Tail > 0 && len(merged) > q.Tail:
+		merged = merged[len(merged)-q.Tail:]
+	case q.Tail == 0 && q.Limit > 0 && len(merged) > q.Limit:
+		merged = merged[:q.Limit]
+	}
+	return merged, nil
+}
+
+// queryRowLogs returns the logs of a job in job_logs matching q, in
+// sequence order.
+func queryRowLogs(tx *sql.Tx, q LogQuery) ([]LogMessage, error) {
+	conds := []string{"job_id = ?"}
+	args := []interface{}{q.JobID}
+	if q.Stream != "" {
+		conds = append(conds, "log_level = ?")
+		args = append(args, q.Stream)
+	}
+	if q.From > 0 {
+		conds = append(conds, "seq >= ?")
+		args = append(args, q.From)
+	}
+	// Times are stored in UTC, in a format that sorts as text
+	if !q.Start.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, q.Start.UTC())
+	}
+	if !q.End.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, q.End.UTC())
+	}
+	if q.Level != "" {
+		levels := levelsFrom(q.Level)
+		conds = append(conds, "level IN (?"+strings.Repeat(", ?", len(levels)-1)+")")
+		for _, level := range levels {
+			args = append(args, level)
+		}
+	}
+
+	// The last lines are selected in reverse and then put back in order
+	order, limit := "ASC", q.Limit
+	if q.Tail > 0 {
+		order, limit = "DESC", q.Tail
+	}
+	query := `
+        SELECT seq, content, log_level, partial, created_at, level, message, logged_at 
+        FROM job_logs 
+        WHERE ` + strings.Join(conds, " AND ") + `
+        ORDER BY seq ` + order + `, id ` + order
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	if q.Tail > 0 {
+		query = `SELECT * FROM (` + query + `) ORDER BY seq ASC`
+	}
+
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query logs: %w", err)
 	}
@@ -226,25 +463,166 @@ func (s *SQLiteStorage) GetJobLogs(jobID string) ([]LogMessage, error) {
 
 	var logs []LogMessage
 	for rows.Next() {
+		var seq int64
 		var content string
+		var stream string
+		var partial bool
 		var createdAt time.Time
+		var level, message sql.NullString
+		var loggedAt sql.NullInt64
 
-		if err := rows.Scan(&content, &createdAt); err != nil {
+		if err := rows.Scan(&seq, &content, &stream, &partial, &createdAt, &level, &message, &loggedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan log row: %w", err)
 		}
 
-		processed := ansi.Process(content)
-		logs = append(logs, LogMessage{
-			JobID:   jobID,
-			Text:    processed.Plain,
-			RawText: processed.Raw,
+		log := LogMessage{
+			JobID:   q.JobID,
+			Seq:     seq,
+			Stream:  stream,
+			Text:    lineText(content),
+			RawText: content,
+			Partial: partial,
 			Time:    createdAt,
-		})
+			Level:   level.String,
+			Message: message.String,
+		}
+		if loggedAt.Valid {
+			log.LoggedAt = time.Unix(0, loggedAt.Int64)
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating log rows: %w", err)
 	}
 
 	return logs, nil
 }
 
+// SearchLogs returns the log lines matching a full-text search, best
+// matches first.
+func (s *SQLiteStorage) SearchLogs(q SearchQuery) ([]SearchMatch, error) {
+	conds := []string{"job_logs_fts MATCH ?"}
+	args := []interface{}{searchExpression(q.Query)}
+	if q.Status != "" {
+		conds = append(conds, "jobs.status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.Start.IsZero() {
+		conds = append(conds, "job_logs_fts.time >= ?")
+		args = append(args, q.Start.UnixNano())
+	}
+	if !q.End.IsZero() {
+		conds = append(conds, "job_logs_fts.time < ?")
+		args = append(args, q.End.UnixNano())
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	args = append(args, limit)
+
+	// Matches are marked with control characters, which can't appear in
+	// the escaped snippet, and turned into tags afterwards
+	rows, err := s.db.Query(`
+        SELECT job_logs_fts.job_id, job_logs_fts.seq, job_logs_fts.stream, job_logs_fts.time,
+               snippet(job_logs_fts, 0, char(2), char(3), '…', 24)
+        FROM job_logs_fts
+        JOIN jobs ON jobs.id = job_logs_fts.job_id
+        WHERE `+strings.Join(conds, " AND ")+`
+        ORDER BY rank
+        LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search logs: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []SearchMatch
+	for rows.Next() {
+		var m SearchMatch
+		var nanos int64
+		var snippet string
+		if err := rows.Scan(&m.JobID, &m.Seq, &m.Stream, &nanos, &snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %w", err)
+		}
+		m.Time = time.Unix(0, nanos).UTC()
+		// Logs written before they were split into lines are indexed
+		// with their ANSI codes. Only escape sequences are removed, the
+		// screen model would also remove the markers.
+		snippet = html.EscapeString(ansi.Strip(snippet))
+		m.Snippet = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(snippet)
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search rows: %w", err)
+	}
+
+	return matches, nil
+}
+
+// searchExpression turns a search query into an FTS5 expression matching
+// lines that contain all of its words. Words are quoted, so FTS5 operators
+// are searched for literally, but a trailing * still matches a prefix.
+func searchExpression(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		prefix := strings.HasSuffix(word, "*") && len(word) > 1
+		word = strings.TrimSuffix(word, "*")
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// JobLogSizes returns the size in bytes of the logs of each job that has
+// any.
+func (s *SQLiteStorage) JobLogSizes() (map[string]int64, error) {
+	rows, err := s.db.Query(`
+        SELECT job_id, SUM(bytes) FROM (
+            SELECT job_id, length(CAST(content AS BLOB)) AS bytes FROM job_logs
+            UNION ALL
+            SELECT job_id, bytes FROM job_log_blocks
+        )
+        GROUP BY job_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log sizes: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var jobID string
+		var size int64
+		if err := rows.Scan(&jobID, &size); err != nil {
+			return nil, fmt.Errorf("failed to scan log size row: %w", err)
+		}
+		sizes[jobID] = size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating log size rows: %w", err)
+	}
+	return sizes, nil
+}
+
+// DatabaseSize returns the number of bytes used by the database. Space
+// freed by removing rows is reused rather than returned to the file
+// system, so it isn't counted.
+func (s *SQLiteStorage) DatabaseSize() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`
+        SELECT (page_count - freelist_count) * page_size
+        FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size()`,
+	).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database size: %w", err)
+	}
+	return size, nil
+}
+
 func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	fmt.Printf("Opening SQLite database at: %s\n", dbPath)
 
@@ -254,24 +632,26 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", "file:"+dbPath)
+	// Pragmas are applied to every pooled connection: foreign keys so that
+	// removing a job deletes its logs, and a busy timeout so concurrent
+	// writers wait for each other instead of failing
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
-	}
-
 	if err := migrate(db); err != nil {
 		return nil, fmt.Errorf("migration failed: %w", err)
 	}
+	s := &SQLiteStorage{db: db}
+	if err := s.compactFinishedJobs(); err != nil {
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
 
 	// Set proper permissions
 	if err := os.Chmod(dbPath, 0600); err != nil {
 		return nil, fmt.Errorf("failed to set database permissions: %w", err)
 	}
 
-	return &SQLiteStorage{db: db}, nil
+	return s, nil
 }
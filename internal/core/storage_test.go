@@ -0,0 +1,147 @@
+package core
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage returns a storage backed by a new database in a temporary
+// directory.
+func newTestStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "srun.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+// createTestJob stores a finished job with the given output lines.
+func createTestJob(t *testing.T, s *SQLiteStorage, id string, lines ...string) {
+	t.Helper()
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	job := &Job{ID: id, JobSpec: JobSpec{Command: "test"}, Status: "completed", StartedAt: start}
+	if err := s.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []LogMessage
+	for i, line := range lines {
+		logs = append(logs, LogMessage{
+			JobID:   id,
+			Seq:     int64(i + 1),
+			Stream:  StreamStdout,
+			Text:    lineText(line),
+			RawText: line,
+			Time:    start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	if err := s.BatchWriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchLogsHighlightsMatches(t *testing.T) {
+	s := newTestStorage(t)
+	createTestJob(t, s, "job", "hello failed world\n", "all good\n")
+	// Logs written before output was split into lines kept their ANSI codes
+	// in the indexed text
+	if _, err := s.db.Exec(`INSERT INTO job_logs_fts (rowid, text, job_id, seq, stream, time)
+        SELECT (log_key << 32) + 3, ?, id, 3, 'stdout', 0 FROM jobs`, "old \x1b[31m failed \x1b[0m<b>"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := s.SearchLogs(SearchQuery{Query: "failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{
+		1: "hello <mark>failed</mark> world",
+		3: "old  <mark>failed</mark> &lt;b&gt;",
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for _, m := range matches {
+		if m.Snippet != want[m.Seq] {
+			t.Errorf("snippet of line %d = %q, want %q", m.Seq, m.Snippet, want[m.Seq])
+		}
+	}
+}
+
+func TestJobLogsKeepTheirStream(t *testing.T) {
+	s := newTestStorage(t)
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := s.CreateJob(&Job{ID: "job", JobSpec: JobSpec{Command: "test"}, Status: "completed", StartedAt: start}); err != nil {
+		t.Fatal(err)
+	}
+	logs := []LogMessage{
+		{JobID: "job", Seq: 1, Stream: StreamStdout, RawText: "out\n", Time: start},
+		{JobID: "job", Seq: 2, Stream: StreamStderr, RawText: "\x1b[31merr\x1b[0m\n", Time: start.Add(time.Second)},
+		{JobID: "job", Seq: 3, RawText: "unknown\n", Time: start.Add(2 * time.Second)},
+	}
+	if err := s.BatchWriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := s.QueryJobLogs(LogQuery{JobID: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ stream, raw, text string }{
+		{StreamStdout, "out\n", "out"},
+		{StreamStderr, "\x1b[31merr\x1b[0m\n", "err"},
+		// Messages without a stream are stdout
+		{StreamStdout, "unknown\n", "unknown"},
+	}
+	if len(stored) != len(want) {
+		t.Fatalf("got %d logs, want %d: %+v", len(stored), len(want), stored)
+	}
+	for i, log := range stored {
+		if log.Stream != want[i].stream || log.RawText != want[i].raw || log.Text != want[i].text {
+			t.Errorf("log %d = %s %q %q, want %s %q %q", i, log.Stream, log.RawText, log.Text, want[i].stream, want[i].raw, want[i].text)
+		}
+	}
+}
+
+func TestSecretValuesAreNotStored(t *testing.T) {
+	s := newTestStorage(t)
+	job := &Job{
+		ID: "job",
+		JobSpec: JobSpec{
+			Command: "deploy",
+			Env:     map[string]string{"USER": "me", "TOKEN": "hunter2"},
+			Secrets: []string{"TOKEN"},
+		},
+		Status:    "completed",
+		StartedAt: time.Now(),
+	}
+	if err := s.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	var env string
+	if err := s.db.QueryRow(`SELECT env FROM jobs WHERE id = ?`, job.ID).Scan(&env); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(env, "hunter2") {
+		t.Errorf("stored environment %s holds the secret's value", env)
+	}
+	stored, err := s.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Env) != 1 || stored.Env["USER"] != "me" || !slices.Equal(stored.Secrets, job.Secrets) {
+		t.Errorf("got env %v and secrets %v, want only USER and the secret's name", stored.Env, stored.Secrets)
+	}
+
+	// Without the secret's value the job can't be restarted
+	pm := NewProcessManager(s)
+	if _, err := pm.RestartJob(job.ID); err == nil || !strings.Contains(err.Error(), "TOKEN") {
+		t.Errorf("restarting got error %v, want one naming the secret", err)
+	}
+}
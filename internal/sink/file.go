@@ -0,0 +1,128 @@
+// Package sink implements destinations for job output besides the
+// database: files on disk, syslog and HTTP log collectors.
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"srun/internal/core"
+)
+
+// File writes the output of each job to its own file, <job ID>.log in a
+// directory, as the job printed it. A file that reaches the size limit is
+// rotated to <job ID>.log.1, shifting older files up, and only the given
+// number of files is kept per job.
+type File struct {
+	dir      string
+	maxBytes int64 // Zero for no limit
+	maxFiles int   // Including the current file
+	files    map[string]*jobFile
+}
+
+// jobFile is the open log file of a job.
+type jobFile struct {
+	f    *os.File
+	size int64
+}
+
+// NewFile returns a File sink writing to dir, which is created if needed.
+func NewFile(dir string, maxBytes int64, maxFiles int) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return &File{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxFiles: max(maxFiles, 1),
+		files:    make(map[string]*jobFile),
+	}, nil
+}
+
+func (s *File) WriteLogs(logs []core.LogMessage) error {
+	for _, log := range logs {
+		jf, err := s.open(log.JobID)
+		if err != nil {
+			return err
+		}
+		if s.maxBytes > 0 && jf.size > 0 && jf.size+int64(len(log.RawText)) > s.maxBytes {
+			if jf, err = s.rotate(log.JobID); err != nil {
+				return err
+			}
+		}
+		n, err := jf.f.WriteString(log.RawText)
+		jf.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write log file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *File) JobFinished(jobID string) error {
+	jf, ok := s.files[jobID]
+	if !ok {
+		return nil
+	}
+	delete(s.files, jobID)
+	return jf.f.Close()
+}
+
+func (s *File) Close() error {
+	var firstErr error
+	for id := range s.files {
+		if err := s.JobFinished(id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *File) path(jobID string) string {
+	return filepath.Join(s.dir, jobID+".log")
+}
+
+// open returns the open file of a job, opening it for appending if needed.
+func (s *File) open(jobID string) (*jobFile, error) {
+	if jf, ok := s.files[jobID]; ok {
+		return jf, nil
+	}
+	f, err := os.OpenFile(s.path(jobID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	jf := &jobFile{f: f, size: info.Size()}
+	s.files[jobID] = jf
+	return jf, nil
+}
+
+// rotate moves a job's current file to .1, shifting older files up and
+// removing the oldest, and opens a new file.
+func (s *File) rotate(jobID string) (*jobFile, error) {
+	if err := s.JobFinished(jobID); err != nil {
+		return nil, fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	base := s.path(jobID)
+	name := func(i int) string {
+		if i == 0 {
+			return base
+		}
+		return fmt.Sprintf("%s.%d", base, i)
+	}
+	if err := os.Remove(name(s.maxFiles - 1)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove old log file: %w", err)
+	}
+	for i := s.maxFiles - 2; i >= 0; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+	return s.open(jobID)
+}
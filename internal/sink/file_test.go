@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"srun/internal/core"
+)
+
+// readFile returns the content of a file, or "" if it doesn't exist.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFile(dir, 12, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []core.LogMessage
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n", "line5\n", "line6\n", "line7\n"} {
+		logs = append(logs, core.LogMessage{JobID: "job", RawText: line})
+	}
+	logs = append(logs, core.LogMessage{JobID: "other", RawText: "other\n"})
+	if err := s.WriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each file holds two lines, and only the newest three files are kept
+	want := map[string]string{
+		"job.log":   "line7\n",
+		"job.log.1": "line5\nline6\n",
+		"job.log.2": "line3\nline4\n",
+		"job.log.3": "",
+		"other.log": "other\n",
+	}
+	for name, content := range want {
+		if got := readFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestFileAppendsToExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFile(dir, 12, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteLogs([]core.LogMessage{{JobID: "job", RawText: "line1\n"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.JobFinished("job"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reopened file keeps its content, which counts towards its size
+	for _, line := range []string{"line2\n", "line3\n"} {
+		if err := s.WriteLogs([]core.LogMessage{{JobID: "job", RawText: line}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "job.log.1")), "line1\nline2\n"; got != want {
+		t.Errorf("job.log.1 = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "job.log")), "line3\n"; got != want {
+		t.Errorf("job.log = %q, want %q", got, want)
+	}
+}
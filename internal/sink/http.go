@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"srun/internal/core"
+)
+
+// Retries of a batch the server failed to accept.
+const (
+	httpAttempts = 3
+	httpBackoff  = 1 * time.Second // Doubled after each attempt
+	httpTimeout  = 10 * time.Second
+)
+
+// HTTP posts batches of log lines to a log collector, in the shape of a
+// Loki push request. Lines are grouped into streams labeled with the job
+// ID and output stream, and the level of JSON log lines.
+type HTTP struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+}
+
+// NewHTTP returns an HTTP sink posting to url with the given extra headers,
+// such as Authorization.
+func NewHTTP(url string, headers http.Header) *HTTP {
+	return &HTTP{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// pushRequest is the body of a Loki push request.
+type pushRequest struct {
+	Streams []pushStream `json:"streams"`
+}
+
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"` // Unix time in nanoseconds and line
+}
+
+func (s *HTTP) WriteLogs(logs []core.LogMessage) error {
+	var req pushRequest
+	streams := make(map[[3]string]int)
+	for _, log := range logs {
+		key := [3]string{log.JobID, log.Stream, log.Level}
+		i, ok := streams[key]
+		if !ok {
+			labels := map[string]string{"source": "srun", "job_id": log.JobID, "stream": log.Stream}
+			if log.Level != "" {
+				labels["level"] = log.Level
+			}
+			i = len(req.Streams)
+			streams[key] = i
+			req.Streams = append(req.Streams, pushStream{Stream: labels})
+		}
+		req.Streams[i].Values = append(req.Streams[i].Values,
+			[2]string{strconv.FormatInt(log.Time.UnixNano(), 10), log.Text})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode logs: %w", err)
+	}
+
+	backoff := httpBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == httpAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a request body, reporting whether a failure may be temporary.
+func (s *HTTP) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send logs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("log server responded %s: %s", resp.Status, bytes.TrimSpace(message))
+}
+
+func (s *HTTP) JobFinished(jobID string) error {
+	return nil
+}
+
+func (s *HTTP) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"srun/internal/core"
+)
+
+// pushServer is a log collector accepting Loki push requests, responding
+// with the given statuses in turn and then with 204.
+type pushServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []pushRequest
+	headers  []http.Header
+}
+
+func newPushServer(t *testing.T, statuses ...int) *pushServer {
+	s := &pushServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, req)
+		s.headers = append(s.headers, r.Header)
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// lines returns the lines received by the server.
+func (s *pushServer) lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for _, req := range s.requests {
+		for _, stream := range req.Streams {
+			for _, value := range stream.Values {
+				lines = append(lines, value[1])
+			}
+		}
+	}
+	return lines
+}
+
+func TestHTTPBatchesLogsIntoStreams(t *testing.T) {
+	srv := newPushServer(t)
+	s := NewHTTP(srv.URL, http.Header{"Authorization": {"Bearer token"}})
+	at := time.Unix(1700000000, 5)
+	logs := []core.LogMessage{
+		{JobID: "a", Stream: core.StreamStdout, Text: "one", Time: at},
+		{JobID: "a", Stream: core.StreamStderr, Text: "oops", Time: at},
+		{JobID: "b", Stream: core.StreamStdout, Text: "other job", Time: at},
+		{JobID: "a", Stream: core.StreamStdout, Text: "two", Time: at},
+		{JobID: "a", Stream: core.StreamStdout, Text: `{"level":"error"}`, Level: "error", Time: at},
+	}
+	if err := s.WriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(srv.requests))
+	}
+	if got := srv.headers[0].Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization header = %q, want the configured one", got)
+	}
+	want := []string{
+		"a stdout  one two",
+		"a stderr  oops",
+		"b stdout  other job",
+		`a stdout error {"level":"error"}`,
+	}
+	streams := srv.requests[0].Streams
+	if len(streams) != len(want) {
+		t.Fatalf("got %d streams, want %d: %+v", len(streams), len(want), streams)
+	}
+	for i, stream := range streams {
+		var texts []string
+		for _, value := range stream.Values {
+			if value[0] != "1700000000000000005" {
+				t.Errorf("stream %d: got time %s, want nanoseconds", i, value[0])
+			}
+			texts = append(texts, value[1])
+		}
+		labels := stream.Stream
+		got := labels["job_id"] + " " + labels["stream"] + " " + labels["level"] + " " + strings.Join(texts, " ")
+		if got != want[i] || labels["source"] != "srun" {
+			t.Errorf("stream %d = %q with labels %v, want %q", i, got, labels, want[i])
+		}
+	}
+}
+
+func TestHTTPRetriesTemporaryFailures(t *testing.T) {
+	srv := newPushServer(t, http.StatusServiceUnavailable)
+	s := NewHTTP(srv.URL, nil)
+	if err := s.WriteLogs([]core.LogMessage{{JobID: "a", Text: "line"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 2 {
+		t.Errorf("got %d requests, want a retry after the failure", len(srv.requests))
+	}
+
+	// Requests the server rejects aren't retried
+	srv = newPushServer(t, http.StatusBadRequest)
+	s = NewHTTP(srv.URL, nil)
+	err := s.WriteLogs([]core.LogMessage{{JobID: "a", Text: "line"}})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("got error %v, want the server's response", err)
+	}
+	if len(srv.requests) != 1 {
+		t.Errorf("got %d requests, want no retries", len(srv.requests))
+	}
+}
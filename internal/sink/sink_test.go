@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"srun/internal/core"
+)
+
+// runJob runs a command in a new process manager with the given sinks, and
+// closes the sinks once the job has finished.
+func runJob(t *testing.T, command string, sinks map[string]core.LogSink, bufferSize int) string {
+	t.Helper()
+	store, err := core.NewSQLiteStorage(filepath.Join(t.TempDir(), "srun.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := core.NewProcessManager(store)
+	for name, sink := range sinks {
+		pm.AddSink(name, sink, bufferSize)
+	}
+	job, err := pm.StartJob(core.JobSpec{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job's log stream ends once all of its output was passed on
+	_, sub, err := pm.SubscribeLogs(job.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != nil {
+		for range sub.C {
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pm.CloseSinks(ctx)
+	return job.ID
+}
+
+// numbers returns the lines "1" to "n".
+func numbers(n int) []string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	return lines
+}
+
+func TestCloseSinksFlushesBufferedLines(t *testing.T) {
+	dir := t.TempDir()
+	file, err := NewFile(dir, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newPushServer(t)
+	id := runJob(t, "seq 1 200", map[string]core.LogSink{"file": file, "http": NewHTTP(srv.URL, nil)}, 0)
+
+	want := numbers(200)
+	if got := readFile(t, filepath.Join(dir, id+".log")); got != strings.Join(want, "\n")+"\n" {
+		t.Errorf("file holds %d bytes, want all lines", len(got))
+	}
+	if got := srv.lines(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("log server got %d lines, want all 200 in order", len(got))
+	}
+}
+
+func TestSlowSinksDropLines(t *testing.T) {
+	// The log server doesn't respond until the job has finished
+	release := make(chan struct{})
+	srv := newPushServer(t)
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer blocking.Close()
+	sink := NewHTTP(blocking.URL, nil)
+
+	time.AfterFunc(500*time.Millisecond, func() { close(release) })
+	runJob(t, "seq 1 200", map[string]core.LogSink{"http": sink}, 10)
+
+	// The job wasn't slowed down, the lines that didn't fit into the
+	// buffer were dropped instead. The sink may have taken a buffer full
+	// before it blocked.
+	got := srv.lines()
+	if len(got) == 0 || len(got) > 2*10 {
+		t.Errorf("log server got %d lines, want at most two buffers full", len(got))
+	}
+	if len(got) > 0 && got[0] != "1" {
+		t.Errorf("log server got %q first, want the first line", got[0])
+	}
+}
@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"srun/internal/core"
+)
+
+// Syslog message fields that don't depend on the log line.
+const (
+	syslogFacility = 1 // user-level messages
+	syslogAppName  = "srun"
+	// SD-ID of the structured data holding the job and sequence number,
+	// using the example enterprise number reserved by RFC 5612
+	syslogSDID = "srun@32473"
+
+	maxSyslogMessage = 8192 // Longer messages are truncated
+	syslogTimeout    = 5 * time.Second
+)
+
+// syslogSeverities maps log levels to syslog severities.
+var syslogSeverities = map[string]int{
+	"trace": 7, // debug
+	"debug": 7,
+	"info":  6, // informational
+	"warn":  4, // warning
+	"error": 3, // error
+	"fatal": 2, // critical
+}
+
+// Syslog sends each log line as an RFC 5424 message to a local syslog
+// daemon over a unix socket, or to a syslog server over UDP.
+type Syslog struct {
+	network  string // unixgram, unix or udp
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslog returns a Syslog sink for an address like unix:///dev/log,
+// udp://host:514 or a socket path.
+func NewSyslog(addr string) (*Syslog, error) {
+	s := &Syslog{hostname: "-"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		s.hostname = hostname
+	}
+
+	if strings.HasPrefix(addr, "/") {
+		addr = "unix://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		s.address = u.Path
+	case "udp":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		s.network, s.address = "udp", u.Host
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q, expected unix:// or udp://", addr)
+	}
+
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dial connects to the syslog daemon. Local daemons usually listen on a
+// datagram socket, stream sockets are used if they don't.
+func (s *Syslog) dial() error {
+	networks := []string{s.network}
+	if s.network == "" || s.network == "unixgram" || s.network == "unix" {
+		networks = []string{"unixgram", "unix"}
+	}
+
+	var err error
+	for _, network := range networks {
+		var conn net.Conn
+		if conn, err = net.DialTimeout(network, s.address, syslogTimeout); err == nil {
+			s.network, s.conn = network, conn
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to connect to syslog: %w", err)
+}
+
+func (s *Syslog) WriteLogs(logs []core.LogMessage) error {
+	for _, log := range logs {
+		msg := s.format(log)
+		if s.network == "unix" {
+			// Stream sockets separate messages with newlines
+			msg += "\n"
+		}
+		if err := s.send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes a message, reconnecting once if the connection was lost.
+func (s *Syslog) send(msg string) error {
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("failed to write to syslog: %w", err)
+	}
+	return nil
+}
+
+// format renders a log line as an RFC 5424 message. The stream is the
+// MSGID, and the job and sequence number are structured data.
+func (s *Syslog) format(log core.LogMessage) string {
+	severity, ok := syslogSeverities[log.Level]
+	if !ok {
+		severity = 6
+		if log.Stream == core.StreamStderr {
+			severity = 3
+		}
+	}
+
+	header := fmt.Sprintf("<%d>1 %s %s %s - %s [%s job=\"%s\" seq=\"%d\"] ",
+		syslogFacility*8+severity,
+		log.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, syslogAppName, log.Stream, syslogSDID,
+		sdEscape(log.JobID), log.Seq)
+	text := log.Text
+	if room := maxSyslogMessage - len(header); len(text) > room {
+		text = truncate(text, room)
+	}
+	return header + text
+}
+
+func (s *Syslog) JobFinished(jobID string) error {
+	return nil
+}
+
+func (s *Syslog) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// sdEscape escapes the characters that are special in structured data
+// parameter values.
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// truncate shortens s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
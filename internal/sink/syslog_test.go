@@ -0,0 +1,60 @@
+package sink
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"srun/internal/core"
+)
+
+func TestSyslogOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := NewSyslog("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.hostname = "host"
+
+	at := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	logs := []core.LogMessage{
+		{JobID: `a"b`, Seq: 1, Stream: core.StreamStdout, Text: "started", Time: at},
+		{JobID: "a", Seq: 2, Stream: core.StreamStderr, Text: "failed", Time: at},
+		{JobID: "a", Seq: 3, Stream: core.StreamStdout, Text: "careful", Level: "warn", Time: at},
+		{JobID: "a", Seq: 4, Stream: core.StreamStdout, Text: strings.Repeat("é", maxSyslogMessage), Time: at},
+	}
+	if err := s.WriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`<14>1 2025-06-01T12:00:00.000000Z host srun - stdout [srun@32473 job="a\"b" seq="1"] started`,
+		`<11>1 2025-06-01T12:00:00.000000Z host srun - stderr [srun@32473 job="a" seq="2"] failed`,
+		`<12>1 2025-06-01T12:00:00.000000Z host srun - stdout [srun@32473 job="a" seq="3"] careful`,
+	}
+	buf := make([]byte, 2*maxSyslogMessage)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range logs {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := string(buf[:n])
+		if i < len(want) {
+			if msg != want[i] {
+				t.Errorf("message %d = %q, want %q", i, msg, want[i])
+			}
+			continue
+		}
+		// Long lines are truncated without splitting characters
+		if n > maxSyslogMessage || !strings.HasSuffix(msg, "é") {
+			t.Errorf("long message has %d bytes ending in %q, want at most %d whole characters", n, msg[n-3:], maxSyslogMessage)
+		}
+	}
+}
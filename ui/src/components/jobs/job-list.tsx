@@ -67,7 +67,7 @@ interface JobListProps {
 export function JobList({ onEditJob }: JobListProps) {
   const [expandedJobId, setExpandedJobId] = useState<string | null>(null);
   const { data: jobs, isLoading } = useJobs();
-  const { stopJob, restartJob, removeJob } = useJobActions();
+  const { stopJob, restartJob, removeJob, pinJob } = useJobActions();
 
   const handleRestart = (id: string) => {
     restartJob.mutate(id, {
@@ -110,6 +110,7 @@ export function JobList({ onEditJob }: JobListProps) {
               onStop={stopJob.mutate}
               onRestart={handleRestart}
               onRemove={removeJob.mutate}
+              onPin={(id, pinned) => pinJob.mutate({ id, pinned })}
               onEdit={onEditJob}
             />
           ))}
@@ -1,7 +1,16 @@
 import { TableCell, TableRow } from "@/components/ui/table";
 import { JobStatusBadge } from "./job-status-badge";
 import { Button } from "@/components/ui/button";
-import { MoreVertical, Play, Square, Trash, Pencil } from "lucide-react";
+import {
+  Download,
+  MoreVertical,
+  Pin,
+  PinOff,
+  Play,
+  Square,
+  Trash,
+  Pencil,
+} from "lucide-react";
 import {
   DropdownMenu,
   DropdownMenuContent,
@@ -10,6 +19,7 @@ import {
 } from "@/components/ui/dropdown-menu";
 import { JobTerminal } from "./job-terminal";
 import { Job } from "@/hooks/use-jobs";
+import { getApiUrl } from "@/config";
 
 interface JobRowProps {
   job: Job;
@@ -18,6 +28,7 @@ interface JobRowProps {
   onStop: (id: string) => void;
   onRestart: (id: string) => void;
   onRemove: (id: string) => void;
+  onPin: (id: string, pinned: boolean) => void;
   onEdit: (command: string) => void;
 }
 
@@ -28,6 +39,7 @@ export function JobRow({
   onStop,
   onRestart,
   onRemove,
+  onPin,
   onEdit,
 }: JobRowProps) {
   return (
@@ -41,14 +53,33 @@ export function JobRow({
           onExpand(expanded ? null : job.id);
         }}
       >
-        <TableCell className="font-mono">{job.id.slice(0, 8)}</TableCell>
+        <TableCell className="font-mono">
+          {job.id.slice(0, 8)}
+          {job.pinned && (
+            <Pin
+              className="ml-1 inline h-3 w-3 text-muted-foreground"
+              aria-label="Pinned"
+            />
+          )}
+        </TableCell>
         <TableCell className="font-mono">{job.pid}</TableCell>
         <TableCell>
           <JobStatusBadge
             status={
-              job.status as "completed" | "running" | "failed" | "stopped"
+              job.status as
+                | "completed"
+                | "running"
+                | "failed"
+                | "stopped"
+                | "timeout"
+                | "lost"
             }
           />
+          {(job.exitCode !== undefined || job.signal) && (
+            <span className="ml-2 font-mono text-xs text-muted-foreground">
+              {job.signal ?? `exit ${job.exitCode}`}
+            </span>
+          )}
         </TableCell>
         <TableCell className="font-mono max-w-md">
           <div 
@@ -93,6 +124,20 @@ export function JobRow({
                   </DropdownMenuItem>
                 </>
               )}
+              <DropdownMenuItem onClick={() => onPin(job.id, !job.pinned)}>
+                {job.pinned ? (
+                  <PinOff className="mr-2 h-4 w-4" />
+                ) : (
+                  <Pin className="mr-2 h-4 w-4" />
+                )}
+                <span>{job.pinned ? "Unpin" : "Pin"}</span>
+              </DropdownMenuItem>
+              <DropdownMenuItem asChild>
+                <a href={getApiUrl(`/api/jobs/${job.id}/logs/download`)}>
+                  <Download className="mr-2 h-4 w-4" />
+                  <span>Download Logs</span>
+                </a>
+              </DropdownMenuItem>
               <DropdownMenuItem
                 onClick={() => onRemove(job.id)}
                 className="text-red-600"
@@ -108,7 +153,7 @@ export function JobRow({
         <TableRow>
           <TableCell colSpan={7} className="p-0 border-0">
             <div className="bg-muted/50">
-              <JobTerminal jobId={job.id} />
+              <JobTerminal jobId={job.id} tty={job.tty} />
             </div>
           </TableCell>
         </TableRow>
@@ -1,7 +1,7 @@
 import { Badge } from "@/components/ui/badge";
 import { cn } from "@/lib/utils";
 
-type JobStatus = "completed" | "running" | "failed" | "stopped";
+type JobStatus = "completed" | "running" | "failed" | "stopped" | "timeout" | "lost";
 
 interface JobStatusBadgeProps {
   status: JobStatus;
@@ -11,6 +11,8 @@ const statusStyles = {
   completed: "bg-green-500/15 text-green-700 hover:bg-green-500/25",
   running: "bg-yellow-500/15 text-yellow-700 hover:bg-yellow-500/25",
   failed: "bg-red-500/15 text-red-700 hover:bg-red-500/25",
+  timeout: "bg-orange-500/15 text-orange-700 hover:bg-orange-500/25",
+  lost: "bg-muted text-muted-foreground hover:bg-muted/80",
   stopped: "bg-muted text-muted-foreground hover:bg-muted/80"
 } as const;
 
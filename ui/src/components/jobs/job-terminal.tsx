@@ -3,11 +3,24 @@ import { Terminal } from "@xterm/xterm";
 import { getWsUrl } from "@/config";
 import "@xterm/xterm/css/xterm.css";
 
+interface LogFrame {
+  type: string;
+  // Missing for previews of an unfinished line
+  seq?: number;
+  stream: "stdout" | "stderr";
+  text: string;
+  // Readable rendering of a JSON log line
+  display?: string;
+  time: string;
+  error?: string;
+}
+
 interface JobTerminalProps {
   jobId: string;
+  tty?: boolean;
 }
 
-export function JobTerminal({ jobId }: JobTerminalProps) {
+export function JobTerminal({ jobId, tty = false }: JobTerminalProps) {
   const terminalRef = useRef<HTMLDivElement>(null);
   const terminal = useRef<Terminal | null>(null);
 
@@ -28,17 +41,46 @@ export function JobTerminal({ jobId }: JobTerminalProps) {
     });
     terminal.current.open(terminalRef.current);
 
-    const wsUrl = getWsUrl(`/api/jobs/${jobId}/logs`);
-    const ws = new WebSocket(wsUrl);
+    // Sequence number of the last message received, so a dropped
+    // connection resumes where it left off
+    let lastSeq = 0;
+    // Text of each stream's unfinished line shown so far. The server sends
+    // an unfinished line from its beginning again after a reconnect, so
+    // what was already shown is skipped.
+    const previews: Record<string, string> = {};
+    let resent: Record<string, string> = {};
+    let disposed = false;
+    let reconnectTimer: ReturnType<typeof setTimeout> | undefined;
+    let ws: WebSocket;
 
-    ws.onmessage = (event) => {
+    const handleMessage = (event: MessageEvent) => {
       try {
-        const { data } = event;
-        // Handle carriage returns for progress updates
-        if (data.includes("\r") && !data.includes("\n")) {
-          terminal.current?.write("\r" + data);
+        const frame: LogFrame = JSON.parse(event.data);
+        if (frame.type === "error") {
+          terminal.current?.writeln(`\r\n\x1b[31mError: ${frame.error}\x1b[0m`);
+          return;
+        }
+        if (frame.type !== "log") return;
+        let text = frame.text;
+        const shown = resent[frame.stream];
+        if (shown !== undefined) {
+          delete resent[frame.stream];
+          if (text.startsWith(shown)) text = text.slice(shown.length);
+        }
+        if (frame.seq) {
+          lastSeq = frame.seq;
+          previews[frame.stream] = "";
+          // A readable rendering replaces the line, unless part of it is
+          // already shown
+          if (frame.display && !shown) text = frame.display;
         } else {
-          terminal.current?.writeln(data);
+          previews[frame.stream] = (shown ?? previews[frame.stream] ?? "") + text;
+        }
+        // Render stderr in red so failures stand out
+        if (frame.stream === "stderr") {
+          terminal.current?.write(`\x1b[31m${text}\x1b[0m`);
+        } else {
+          terminal.current?.write(text);
         }
       } catch (error) {
         console.error("Failed to parse message:", error, event.data);
@@ -48,19 +90,70 @@ export function JobTerminal({ jobId }: JobTerminalProps) {
       }
     };
 
-    ws.onerror = (error) => {
-      console.error("WebSocket error:", error);
+    // Forward keyboard input to the job's stdin. A terminal handles echo
+    // and line editing itself, pipes don't, so for those input is echoed
+    // locally and Enter is sent as a newline.
+    const input = terminal.current.onData((data) => {
+      if (ws.readyState !== WebSocket.OPEN) return;
+      if (tty) {
+        ws.send(JSON.stringify({ type: "stdin", data }));
+        return;
+      }
+      if (data === "\x04") {
+        // Ctrl-D closes stdin
+        ws.send(JSON.stringify({ type: "eof" }));
+        return;
+      }
+      terminal.current?.write(data.replace(/\r/g, "\r\n"));
+      ws.send(JSON.stringify({ type: "stdin", data: data.replace(/\r/g, "\n") }));
+    });
+
+    // Keep the job's terminal size in sync with xterm
+    const sendSize = (cols: number, rows: number) => {
+      if (tty && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({ type: "resize", cols, rows }));
+      }
     };
+    const resize = terminal.current.onResize(({ cols, rows }) =>
+      sendSize(cols, rows),
+    );
 
-    ws.onclose = () => {
-      console.log("WebSocket closed");
+    const connect = () => {
+      resent = Object.fromEntries(
+        Object.entries(previews).filter(([, text]) => text !== ""),
+      );
+      ws = new WebSocket(
+        getWsUrl(`/api/jobs/${jobId}/logs?format=json&since=${lastSeq}`),
+      );
+      ws.onmessage = handleMessage;
+      ws.onopen = () => {
+        if (terminal.current) {
+          sendSize(terminal.current.cols, terminal.current.rows);
+        }
+      };
+      ws.onerror = (error) => {
+        console.error("WebSocket error:", error);
+      };
+      ws.onclose = (event) => {
+        console.log("WebSocket closed");
+        // The server closes normally once the job has finished, anything
+        // else is a dropped connection
+        if (!disposed && event.code !== 1000) {
+          reconnectTimer = setTimeout(connect, 1000);
+        }
+      };
     };
+    connect();
 
     return () => {
+      disposed = true;
+      clearTimeout(reconnectTimer);
+      input.dispose();
+      resize.dispose();
       ws.close();
       terminal.current?.dispose();
     };
-  }, [jobId]);
+  }, [jobId, tty]);
 
   return (
     <div ref={terminalRef} className="h-[500px] bg-[#1a1b1e] rounded-md p-4" />
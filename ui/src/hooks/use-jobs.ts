@@ -9,6 +9,10 @@ export interface Job {
   status: string;
   startedAt: string;
   completedAt?: string;
+  exitCode?: number;
+  signal?: string;
+  tty?: boolean;
+  pinned?: boolean;
 }
 
 export function useJobs() {
@@ -93,9 +97,26 @@ export function useJobActions() {
     },
   });
 
+  const pinJob = useMutation({
+    mutationFn: async ({ id, pinned }: { id: string; pinned: boolean }) => {
+      const action = pinned ? "pin" : "unpin";
+      const response = await fetch(getApiUrl(`/api/jobs/${id}/${action}`), {
+        method: "POST",
+      });
+      if (!response.ok) throw new Error(`Failed to ${action} job`);
+    },
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["jobs"] });
+    },
+    onError: (error) => {
+      toast.error(error.message);
+    },
+  });
+
   return {
     stopJob,
     restartJob,
     removeJob,
+    pinJob,
   };
 }